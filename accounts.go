@@ -0,0 +1,205 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The policies for a run-as user that is neither one of the configured users
+// nor a numeric UID.
+const (
+	// Fail, which powers off the VM (the default).
+	missingRunAsFail = "fail"
+	// Run as root.
+	missingRunAsRoot = "root"
+	// Add the user to /etc/passwd, with the next free UID.
+	missingRunAsCreate = "create"
+)
+
+// The policies for a run-as user that matches more than one configured user
+// (by name or UID).
+const (
+	// Use the first match, in the order the users are configured (the
+	// default).
+	duplicateRunAsFirst = "first"
+	// Use the match with the lowest UID.
+	duplicateRunAsLowestUID = "lowest-uid"
+	// Fail, which powers off the VM.
+	duplicateRunAsFail = "fail"
+)
+
+// firstCreatedUID is where we start looking for a free UID for users we
+// create, which is the usual start of the range for regular users.
+const firstCreatedUID = 1000
+
+// resolveRunAs returns the uid and gid the entrypoint should run as (default
+// to 0), along with the configured user they belong to, if any.
+func resolveRunAs(accts ImageAccounts, policy, duplicates string) (uid, gid int, user *User, err error) {
+	if accts.RunAs == "" {
+		return 0, 0, nil, nil
+	}
+	// Search for a user whose name matches the runAs and if we find one
+	// then set uid to that user's UID.
+	runAs := accts.RunAs
+	var matches []int
+	for i, acct := range accts.Users {
+		if acct.UserName == runAs || fmt.Sprint(acct.UID) == runAs {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) > 0 {
+		i := matches[0]
+		if len(matches) > 1 {
+			switch duplicates {
+			case duplicateRunAsFail:
+				return 0, 0, nil, fmt.Errorf("run-as user %q matches %d configured users", runAs, len(matches))
+			case duplicateRunAsLowestUID:
+				for _, j := range matches[1:] {
+					if accts.Users[j].UID < accts.Users[i].UID {
+						i = j
+					}
+				}
+			case "", duplicateRunAsFirst:
+			default:
+				return 0, 0, nil, fmt.Errorf("unknown duplicate-run-as policy %q", duplicates)
+			}
+			log.Printf("run-as user %q matches %d configured users, using UID %d", runAs, len(matches), accts.Users[i].UID)
+		}
+		acct := accts.Users[i]
+		return int(acct.UID), int(acct.GID), &accts.Users[i], nil
+	}
+	if runAs == "root" {
+		return 0, 0, nil, nil
+	}
+	// Otherwise, try to parse the runAs as a UID.
+	if uid, err := strconv.ParseUint(runAs, 10, 32); err == nil {
+		return int(uid), 0, nil, nil
+	}
+
+	// The run-as user is unknown, which is most likely a typo.
+	switch policy {
+	case missingRunAsRoot:
+		log.Printf("run-as user %q is not configured, running as root", runAs)
+		return 0, 0, nil, nil
+	case missingRunAsCreate:
+		u, err := createUser(accts, runAs)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("creating run-as user %q: %w", runAs, err)
+		}
+		return int(u.UID), int(u.GID), u, nil
+	default:
+		return 0, 0, nil, fmt.Errorf("run-as user %q is not one of the configured users", runAs)
+	}
+}
+
+// createUser adds a user (and a group of the same name and ID) with the next
+// free UID to /etc/passwd and /etc/group.
+func createUser(accts ImageAccounts, name string) (*User, error) {
+	used := make(map[uint32]bool, len(accts.Users))
+	for _, u := range accts.Users {
+		used[u.UID] = true
+	}
+	if b, err := os.ReadFile("/etc/passwd"); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			// Each line looks like: name:password:uid:gid:gecos:home:shell
+			fields := strings.Split(line, ":")
+			if len(fields) < 3 {
+				continue
+			}
+			if uid, err := strconv.ParseUint(fields[2], 10, 32); err == nil {
+				used[uint32(uid)] = true
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	uid := uint32(firstCreatedUID)
+	for used[uid] {
+		uid++
+	}
+
+	u := &User{UserName: name, UID: uid, GID: uid}
+	if err := appendLine("/etc/passwd", fmt.Sprintf("%s:x:%d:%d::/home/%s:/bin/sh", name, uid, uid, name)); err != nil {
+		return nil, err
+	}
+	if err := appendLine("/etc/group", fmt.Sprintf("%s:x:%d:", name, uid)); err != nil {
+		return nil, err
+	}
+	log.Printf("created run-as user %q with uid %d", name, uid)
+	return u, nil
+}
+
+// appendLine appends a line to the file, creating it if needed.
+func appendLine(path, line string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// supplementaryGroups returns the GIDs of the groups that name is a member
+// of, according to both the configured groups and /etc/group, excluding the
+// primary group gid.
+func supplementaryGroups(accts ImageAccounts, name string, gid int) []uint32 {
+	if name == "" {
+		return nil
+	}
+	var gids []uint32
+	add := func(g uint32) {
+		if int(g) != gid && !slices.Contains(gids, g) {
+			gids = append(gids, g)
+		}
+	}
+	for _, g := range accts.Groups {
+		if slices.Contains(g.Members, name) {
+			add(g.GID)
+		}
+	}
+
+	f, err := os.Open("/etc/group")
+	if errors.Is(err, os.ErrNotExist) {
+		return gids
+	} else if err != nil {
+		log.Printf("failed to read /etc/group: %v", err)
+		return gids
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// Each line looks like: name:password:gid:member1,member2
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) != 4 {
+			continue
+		}
+		if !slices.Contains(strings.Split(fields[3], ","), name) {
+			continue
+		}
+		g, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			log.Printf("ignoring malformed /etc/group entry %q: %v", fields[0], err)
+			continue
+		}
+		add(uint32(g))
+	}
+	if err := s.Err(); err != nil {
+		log.Printf("failed to read /etc/group: %v", err)
+	}
+	return gids
+}
@@ -0,0 +1,116 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSupplementaryGroups(t *testing.T) {
+	// A name that no group on the machine running the tests lists as a member,
+	// so that only the configured groups count.
+	const name = "wolfinit-test-user"
+	accts := ImageAccounts{
+		Groups: []Group{
+			{GroupName: "primary", GID: 100, Members: []string{name}},
+			{GroupName: "wheel", GID: 10, Members: []string{"root", name}},
+			{GroupName: "audio", GID: 63, Members: []string{name}},
+			{GroupName: "video", GID: 39, Members: []string{"someone-else"}},
+			{GroupName: "wheel-again", GID: 10, Members: []string{name}},
+		},
+	}
+
+	for _, tc := range []struct {
+		name string
+		user string
+		gid  int
+		want []uint32
+	}{{
+		name: "member of several groups",
+		user: name,
+		gid:  100,
+		want: []uint32{10, 63},
+	}, {
+		name: "primary group is not supplementary",
+		user: name,
+		gid:  63,
+		want: []uint32{100, 10},
+	}, {
+		name: "member of none",
+		user: "nobody-at-all",
+		gid:  100,
+	}, {
+		name: "no user",
+		gid:  100,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := supplementaryGroups(accts, tc.user, tc.gid); !slices.Equal(got, tc.want) {
+				t.Errorf("supplementaryGroups() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveRunAs(t *testing.T) {
+	users := []User{
+		{UserName: "app", UID: 1001, GID: 1001},
+		{UserName: "other", UID: 1002, GID: 1002},
+		{UserName: "app", UID: 900, GID: 901},
+		{UserName: "third", UID: 1001, GID: 1003},
+	}
+	for _, tc := range []struct {
+		name       string
+		runAs      string
+		policy     string
+		duplicates string
+		uid, gid   int
+		user       string
+		wantErr    bool
+	}{
+		{name: "unset", runAs: ""},
+		{name: "by name", runAs: "other", uid: 1002, gid: 1002, user: "other"},
+		{name: "by uid", runAs: "1002", uid: 1002, gid: 1002, user: "other"},
+		{name: "root", runAs: "root"},
+		{name: "unconfigured uid", runAs: "4242", uid: 4242},
+		{name: "missing", runAs: "nobody-here", wantErr: true},
+		{name: "missing as root", runAs: "nobody-here", policy: missingRunAsRoot},
+		{name: "duplicate name, first", runAs: "app", uid: 1001, gid: 1001, user: "app"},
+		{name: "duplicate name, explicitly first", runAs: "app", duplicates: duplicateRunAsFirst, uid: 1001, gid: 1001, user: "app"},
+		{name: "duplicate name, lowest uid", runAs: "app", duplicates: duplicateRunAsLowestUID, uid: 900, gid: 901, user: "app"},
+		{name: "duplicate name, fail", runAs: "app", duplicates: duplicateRunAsFail, wantErr: true},
+		{name: "duplicate uid, first", runAs: "1001", uid: 1001, gid: 1001, user: "app"},
+		{name: "duplicate uid, lowest uid keeps the first", runAs: "1001", duplicates: duplicateRunAsLowestUID, uid: 1001, gid: 1001, user: "app"},
+		{name: "duplicate uid, fail", runAs: "1001", duplicates: duplicateRunAsFail, wantErr: true},
+		{name: "unknown duplicates policy", runAs: "app", duplicates: "random", wantErr: true},
+		// The policy only matters when there are duplicates.
+		{name: "unique with fail", runAs: "other", duplicates: duplicateRunAsFail, uid: 1002, gid: 1002, user: "other"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			accts := ImageAccounts{RunAs: tc.runAs, Users: users}
+			uid, gid, user, err := resolveRunAs(accts, tc.policy, tc.duplicates)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("resolveRunAs() = %d, %d, want an error", uid, gid)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("resolveRunAs() = %v", err)
+			}
+			if uid != tc.uid || gid != tc.gid {
+				t.Errorf("resolveRunAs() = %d, %d, want %d, %d", uid, gid, tc.uid, tc.gid)
+			}
+			var name string
+			if user != nil {
+				name = user.UserName
+			}
+			if name != tc.user {
+				t.Errorf("resolveRunAs() user = %q, want %q", name, tc.user)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// The defaults for a configured restart backoff.
+const (
+	defaultBackoffBase       = time.Second
+	defaultBackoffMax        = time.Minute
+	defaultBackoffMultiplier = 2
+	defaultBackoffResetAfter = time.Minute
+)
+
+// restartBackoff computes the delays between restarts of the entrypoint.
+type restartBackoff struct {
+	base, max, resetAfter time.Duration
+	multiplier            float64
+	// The delay before the next restart, before jitter.
+	next time.Duration
+}
+
+// newRestartBackoff returns a backoff for cfg, filling in the defaults.
+func newRestartBackoff(cfg Backoff) *restartBackoff {
+	b := &restartBackoff{
+		base:       defaultBackoffBase,
+		max:        defaultBackoffMax,
+		resetAfter: defaultBackoffResetAfter,
+		multiplier: defaultBackoffMultiplier,
+	}
+	if cfg.Base != nil {
+		b.base = time.Duration(*cfg.Base)
+	}
+	if cfg.Max != nil {
+		b.max = time.Duration(*cfg.Max)
+	}
+	if cfg.ResetAfter != nil {
+		b.resetAfter = time.Duration(*cfg.ResetAfter)
+	}
+	if cfg.Multiplier >= 1 {
+		b.multiplier = cfg.Multiplier
+	}
+	b.next = b.base
+	return b
+}
+
+// delay returns how long to wait before restarting an entrypoint that was up
+// for uptime, and moves on to the next delay. Half of each delay is
+// randomized, so that VMs failing together don't restart in lockstep.
+func (b *restartBackoff) delay(uptime time.Duration) time.Duration {
+	var d time.Duration
+	d, b.next = b.step(uptime)
+	return jitter(d)
+}
+
+// step returns the delay (before jitter) before restarting an entrypoint that
+// was up for uptime, along with the delay after that. The delay grows by the
+// multiplier with each restart, up to the max, and goes back to the base once
+// the entrypoint stays up for resetAfter.
+func (b *restartBackoff) step(uptime time.Duration) (d, next time.Duration) {
+	d = b.next
+	if uptime >= b.resetAfter {
+		d = b.base
+	}
+	if d > b.max {
+		d = b.max
+	}
+	next = time.Duration(float64(d) * b.multiplier)
+	if next > b.max {
+		next = b.max
+	}
+	return d, next
+}
+
+// jitter returns a random delay between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,87 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoffStep(t *testing.T) {
+	dur := func(d time.Duration) *Duration {
+		v := Duration(d)
+		return &v
+	}
+	long := 2 * time.Minute
+
+	for _, tc := range []struct {
+		name    string
+		cfg     Backoff
+		uptimes []time.Duration
+		want    []time.Duration
+	}{{
+		name:    "defaults",
+		uptimes: []time.Duration{0, 0, 0},
+		want:    []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+	}, {
+		name:    "grows up to the max",
+		cfg:     Backoff{Base: dur(time.Second), Max: dur(10 * time.Second)},
+		uptimes: []time.Duration{0, 0, 0, 0, 0, 0},
+		want:    []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second},
+	}, {
+		name:    "multiplier",
+		cfg:     Backoff{Base: dur(time.Second), Multiplier: 3},
+		uptimes: []time.Duration{0, 0, 0},
+		want:    []time.Duration{1 * time.Second, 3 * time.Second, 9 * time.Second},
+	}, {
+		name:    "a multiplier below 1 is ignored",
+		cfg:     Backoff{Base: dur(time.Second), Multiplier: 0.5},
+		uptimes: []time.Duration{0, 0, 0},
+		want:    []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
+	}, {
+		name:    "resets after staying up",
+		cfg:     Backoff{Base: dur(time.Second)},
+		uptimes: []time.Duration{0, 0, 0, long, 0},
+		want:    []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 1 * time.Second, 2 * time.Second},
+	}, {
+		name:    "reset threshold",
+		cfg:     Backoff{Base: dur(time.Second), ResetAfter: dur(10 * time.Second)},
+		uptimes: []time.Duration{0, 9 * time.Second, 10 * time.Second},
+		want:    []time.Duration{1 * time.Second, 2 * time.Second, 1 * time.Second},
+	}, {
+		name:    "base above the max",
+		cfg:     Backoff{Base: dur(time.Minute), Max: dur(time.Second)},
+		uptimes: []time.Duration{0, 0},
+		want:    []time.Duration{time.Second, time.Second},
+	}, {
+		name:    "no delay",
+		cfg:     Backoff{Base: dur(0)},
+		uptimes: []time.Duration{0, 0},
+		want:    []time.Duration{0, 0},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newRestartBackoff(tc.cfg)
+			for i, uptime := range tc.uptimes {
+				var d time.Duration
+				d, b.next = b.step(uptime)
+				if d != tc.want[i] {
+					t.Errorf("restart %d (up %v): delay = %v, want %v", i, uptime, d, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, time.Millisecond, time.Second, time.Minute} {
+		for i := 0; i < 100; i++ {
+			if got := jitter(d); got < d/2 || got > d {
+				t.Fatalf("jitter(%v) = %v, want between %v and %v", d, got, d/2, d)
+			}
+		}
+	}
+}
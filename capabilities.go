@@ -0,0 +1,103 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// capabilityNames are the names of the capabilities, indexed by number.
+var capabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+	"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+	"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+	"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+	"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
+
+// parseCapabilities returns the numbers of the named capabilities, which may
+// be given as e.g. CAP_NET_RAW or net_raw.
+func parseCapabilities(names []string) ([]uintptr, error) {
+	caps := make([]uintptr, 0, len(names))
+	for _, name := range names {
+		n := strings.TrimPrefix(strings.ToLower(name), "cap_")
+		found := false
+		for i, c := range capabilityNames {
+			if c == n {
+				caps = append(caps, uintptr(i))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+	}
+	return caps, nil
+}
+
+// lastCapability returns the highest capability the kernel knows about.
+func lastCapability() int {
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return len(capabilityNames) - 1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return len(capabilityNames) - 1
+	}
+	return n
+}
+
+// dropCapabilities drops every capability but keep from the calling thread's
+// bounding set, so that a process forked from it can never gain the others.
+// This must be done on a thread given to startOnThread.
+func dropCapabilities(keep []uintptr) error {
+	kept := make(map[uintptr]bool, len(keep))
+	for _, c := range keep {
+		kept[c] = true
+	}
+	for c := uintptr(0); c <= uintptr(lastCapability()); c++ {
+		if kept[c] {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, c, 0, 0, 0); err != nil {
+			return fmt.Errorf("dropping capability %d: %w", c, err)
+		}
+	}
+	return nil
+}
+
+// startOnThread calls start (e.g. cmd.Start) on a dedicated thread, after
+// calling each of prepare on it. This is for attributes that belong to the
+// thread (e.g. the capability bounding set), which the forked process
+// inherits, but which can't be undone. The thread is thrown away afterwards
+// (by never unlocking it), so init itself keeps what it had (e.g. the
+// CAP_NET_ADMIN and CAP_NET_RAW that DHCP needs).
+func startOnThread(prepare []func() error, start func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		for _, p := range prepare {
+			if err := p(); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- start()
+	}()
+	return <-errc
+}
@@ -0,0 +1,119 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The bundle that TLS clients read the trusted CAs from.
+	caBundle = "/etc/ssl/certs/ca-certificates.crt"
+	// Where update-ca-certificates picks up local CAs from.
+	localCADir = "/usr/local/share/ca-certificates"
+)
+
+// readCACerts returns the PEM encoding of the certificates in a CA
+// certificate setting, which is either inline PEM or the path of a PEM file.
+// Any block that isn't a valid certificate is an error.
+func readCACerts(setting string) ([]byte, int, error) {
+	b := []byte(setting)
+	if !strings.HasPrefix(strings.TrimSpace(setting), "-----BEGIN") {
+		var err error
+		if b, err = os.ReadFile(setting); err != nil {
+			return nil, 0, err
+		}
+	}
+	var out bytes.Buffer
+	n := 0
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, 0, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, 0, err
+		}
+		if err := pem.Encode(&out, block); err != nil {
+			return nil, 0, err
+		}
+		n++
+	}
+	if len(bytes.TrimSpace(b)) != 0 {
+		return nil, 0, fmt.Errorf("trailing data that isn't PEM")
+	} else if n == 0 {
+		return nil, 0, fmt.Errorf("no certificates found")
+	}
+	return out.Bytes(), n, nil
+}
+
+// installCACerts adds the given CA certificates to the system trust store. If
+// the image has update-ca-certificates, they are installed for it to pick up,
+// and otherwise they are appended to the bundle directly.
+func installCACerts(ctx context.Context, settings []string) error {
+	var certs [][]byte
+	total := 0
+	for i, s := range settings {
+		b, n, err := readCACerts(s)
+		if err != nil {
+			return fmt.Errorf("reading CA certificate %d: %w", i, err)
+		}
+		certs = append(certs, b)
+		total += n
+	}
+
+	if _, err := exec.LookPath("update-ca-certificates"); err == nil {
+		if err := os.MkdirAll(localCADir, 0755); err != nil {
+			return err
+		}
+		for i, b := range certs {
+			path := filepath.Join(localCADir, fmt.Sprintf("wolfinit-%d.crt", i))
+			if err := os.WriteFile(path, b, 0644); err != nil {
+				return err
+			}
+		}
+		cmd := exec.CommandContext(ctx, "update-ca-certificates")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := runWaited(cmd); err != nil {
+			return fmt.Errorf("running update-ca-certificates: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(caBundle), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(caBundle, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		for _, b := range certs {
+			// Make sure we don't join onto an unterminated last line.
+			if _, err := f.Write(append([]byte("\n"), b...)); err != nil {
+				return err
+			}
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	log.Printf("added %d CA certificates to the trust store", total)
+	return nil
+}
@@ -0,0 +1,298 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/moby/sys/mount"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// The name of the cgroup the entrypoint is placed in.
+	entrypointCgroup = "entrypoint"
+	// The CFS period we express CPU limits against.
+	cpuPeriod = 100000
+)
+
+// cgroup is a cgroup created for the entrypoint.
+type cgroup struct {
+	dir string
+	v2  bool
+	// With cgroup v1 controllers mounted one at a time, the entrypoint has a
+	// cgroup in each controller's hierarchy that it is limited by, rather
+	// than just dir.
+	dirs []string
+	// With cgroup v2, an open handle on dir used to start processes in it.
+	fd *os.File
+}
+
+// kernelSupportsCgroup2 returns whether the kernel can mount the unified
+// (v2) hierarchy.
+func kernelSupportsCgroup2() bool {
+	b, err := os.ReadFile("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		// e.g. "nodev	cgroup2"
+		if fields := strings.Fields(line); len(fields) > 0 && fields[len(fields)-1] == "cgroup2" {
+			return true
+		}
+	}
+	return false
+}
+
+// mountCgroups mounts the cgroup hierarchy at cgroupRoot, creating it if the
+// image (or kernel) doesn't provide it. The unified (v2) hierarchy is used
+// where the kernel supports it, and otherwise every v1 controller, together
+// if the kernel allows it.
+func mountCgroups() {
+	if err := os.MkdirAll(cgroupRoot, 0755); err != nil {
+		log.Printf("failed to create %s: %v", cgroupRoot, err)
+		return
+	}
+	if kernelSupportsCgroup2() {
+		// mount -t cgroup2 -o nsdelegate cgroup2 /sys/fs/cgroup
+		if err := mount.Mount("cgroup2", cgroupRoot, "cgroup2", "nsdelegate"); err != nil {
+			log.Printf("failed to mount cgroup v2: %v", err)
+			return
+		}
+		log.Printf("mounted cgroup v2 at %s", cgroupRoot)
+		return
+	}
+	// mount -t cgroup -o all cgroup /sys/fs/cgroup
+	if err := mount.Mount("cgroup", cgroupRoot, "cgroup", "all"); err != nil {
+		log.Printf("failed to mount cgroup v1 with all controllers, mounting them one at a time: %v", err)
+		mountCgroupControllers()
+		return
+	}
+	log.Printf("mounted cgroup v1 at %s", cgroupRoot)
+}
+
+// mountCgroupControllers mounts each enabled v1 controller in its own
+// hierarchy under cgroupRoot (e.g. /sys/fs/cgroup/memory), the way systemd
+// lays them out, for kernels that reject mounting them all together.
+func mountCgroupControllers() {
+	controllers, err := cgroupControllers()
+	if err != nil {
+		log.Printf("failed to list cgroup controllers: %v", err)
+		return
+	}
+	// mount -t tmpfs -o mode=755 cgroup /sys/fs/cgroup
+	if err := mount.Mount("cgroup", cgroupRoot, "tmpfs", "nosuid,nodev,noexec,mode=755"); err != nil {
+		log.Printf("failed to mount tmpfs at %s: %v", cgroupRoot, err)
+		return
+	}
+	var mounted []string
+	for _, c := range controllers {
+		dir := filepath.Join(cgroupRoot, c)
+		if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+			log.Printf("failed to create %s: %v", dir, err)
+			continue
+		}
+		// mount -t cgroup -o <controller> cgroup /sys/fs/cgroup/<controller>
+		if err := mount.Mount("cgroup", dir, "cgroup", c); err != nil {
+			log.Printf("failed to mount cgroup v1 controller %s: %v", c, err)
+			continue
+		}
+		mounted = append(mounted, c)
+	}
+	log.Printf("mounted cgroup v1 controllers at %s: %s", cgroupRoot, strings.Join(mounted, ", "))
+}
+
+// cgroupControllers returns the enabled v1 controllers, from /proc/cgroups.
+func cgroupControllers() ([]string, error) {
+	b, err := os.ReadFile("/proc/cgroups")
+	if err != nil {
+		return nil, err
+	}
+	var controllers []string
+	for _, line := range strings.Split(string(b), "\n") {
+		// e.g. "memory	0	1	1", after a "#subsys_name ..." header.
+		fields := strings.Fields(line)
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") || fields[3] != "1" {
+			continue
+		}
+		controllers = append(controllers, fields[0])
+	}
+	return controllers, nil
+}
+
+// isCgroup2 returns whether the unified (v2) hierarchy is mounted.
+func isCgroup2() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// isSplitCgroup1 returns whether the v1 controllers are mounted one at a time
+// under cgroupRoot, rather than together at it.
+func isSplitCgroup1() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.procs"))
+	return err != nil
+}
+
+// newCgroup creates the entrypoint's cgroup and applies the given limits.
+func newCgroup(res Resources) (*cgroup, error) {
+	cg := &cgroup{
+		dir: filepath.Join(cgroupRoot, entrypointCgroup),
+		v2:  isCgroup2(),
+	}
+	if cg.v2 {
+		// Controllers must be enabled in the parent for their files to show
+		// up in the child.
+		if err := os.WriteFile(filepath.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644); err != nil {
+			return nil, fmt.Errorf("enabling controllers: %w", err)
+		}
+	}
+	split := !cg.v2 && isSplitCgroup1()
+	if !split {
+		if err := os.Mkdir(cg.dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		cg.dirs = []string{cg.dir}
+	}
+
+	// The cgroup v2 files, and their cgroup v1 equivalents.
+	var files [][2]string
+	if res.Memory != "" {
+		files = append(files, [2]string{"memory.max", res.Memory})
+	}
+	if res.CPUs != 0 {
+		quota := int64(res.CPUs * cpuPeriod)
+		if cg.v2 {
+			files = append(files, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)})
+		} else {
+			files = append(files,
+				[2]string{"cpu.cfs_period_us", strconv.Itoa(cpuPeriod)},
+				[2]string{"cpu.cfs_quota_us", strconv.FormatInt(quota, 10)})
+		}
+	}
+	if res.Pids != 0 {
+		files = append(files, [2]string{"pids.max", strconv.FormatInt(res.Pids, 10)})
+	}
+	for _, f := range files {
+		name, value := f[0], f[1]
+		if !cg.v2 && name == "memory.max" {
+			name = "memory.limit_in_bytes"
+		}
+		dir := cg.dir
+		if split {
+			// e.g. /sys/fs/cgroup/memory/entrypoint for memory.limit_in_bytes
+			controller, _, _ := strings.Cut(name, ".")
+			dir = filepath.Join(cgroupRoot, controller, entrypointCgroup)
+			// It already exists after another of the controller's files.
+			if err := os.Mkdir(dir, 0755); err == nil {
+				cg.dirs = append(cg.dirs, dir)
+			} else if !errors.Is(err, os.ErrExist) {
+				return nil, err
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			return nil, fmt.Errorf("setting %s: %w", name, err)
+		}
+		log.Printf("set %s of the entrypoint cgroup to %s", name, value)
+	}
+	if cg.v2 {
+		f, err := os.Open(cg.dir)
+		if err != nil {
+			return nil, err
+		}
+		cg.fd = f
+	}
+	return cg, nil
+}
+
+// apply arranges for the process started with attr to be created directly
+// in the cgroup, where the kernel supports that (cgroup v2).
+func (cg *cgroup) apply(attr *syscall.SysProcAttr) {
+	if cg.fd == nil {
+		return
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(cg.fd.Fd())
+}
+
+// add moves the started process into the cgroup, if it wasn't created there.
+func (cg *cgroup) add(pid int) error {
+	if cg.v2 {
+		return nil
+	}
+	for _, dir := range cg.dirs {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchOOM logs when processes in the cgroup are OOM killed, as counted in
+// its (cgroup v2) memory.events, which the kernel signals changes to with
+// inotify. The returned function stops watching.
+func (cg *cgroup) watchOOM() (func(), error) {
+	if !cg.v2 {
+		return nil, errors.New("OOM events need cgroup v2")
+	}
+	events := filepath.Join(cg.dir, "memory.events")
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	// Being non-blocking, this uses the poller, so closing it interrupts the
+	// read below.
+	f := os.NewFile(uintptr(fd), "inotify")
+	if _, err := unix.InotifyAddWatch(fd, events, unix.IN_MODIFY); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var kills uint64
+		buf := make([]byte, 4096)
+		for {
+			if _, err := f.Read(buf); err != nil {
+				if !errors.Is(err, os.ErrClosed) {
+					log.Printf("failed to watch %s: %v", events, err)
+				}
+				return
+			}
+			b, err := os.ReadFile(events)
+			if err != nil {
+				log.Printf("failed to read %s: %v", events, err)
+				continue
+			}
+			// Each line looks like: oom_kill 1
+			for _, line := range strings.Split(string(b), "\n") {
+				k, v, _ := strings.Cut(line, " ")
+				if k != "oom_kill" {
+					continue
+				}
+				n, err := strconv.ParseUint(v, 10, 64)
+				if err != nil || n <= kills {
+					break
+				}
+				log.Printf("the entrypoint's cgroup ran out of memory: %d processes were OOM killed (%d in total)", n-kills, n)
+				kills = n
+			}
+		}
+	}()
+	return func() {
+		f.Close()
+		<-done
+	}, nil
+}
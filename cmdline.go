@@ -0,0 +1,203 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cmdlinePrefix is the prefix of kernel command line parameters that are
+// interpreted by wolfinit, e.g. wolfinit.config_retries=10
+const cmdlinePrefix = "wolfinit."
+
+// splitCmdline splits the kernel command line into its parameters. As with
+// the kernel, double quotes may be used to include spaces in a value, and
+// are removed, e.g. foo="bar baz" yields foo=bar baz.
+func splitCmdline(s string) []string {
+	var (
+		params []string
+		cur    strings.Builder
+		quoted bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if cur.Len() > 0 {
+				params = append(params, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		params = append(params, cur.String())
+	}
+	return params
+}
+
+// readCmdline returns the parameters on the kernel command line as a map
+// from key to value. Parameters without a value map to the empty string.
+func readCmdline() map[string]string {
+	b, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		log.Printf("failed to read /proc/cmdline: %v", err)
+		return nil
+	}
+	params := make(map[string]string)
+	for _, p := range splitCmdline(string(b)) {
+		k, v, _ := strings.Cut(p, "=")
+		params[k] = v
+	}
+	return params
+}
+
+// cmdlineInt returns the named wolfinit parameter as an integer, or def if it
+// is absent or malformed.
+func cmdlineInt(params map[string]string, name string, def int) int {
+	v, ok := params[cmdlinePrefix+name]
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("ignoring malformed %s%s=%q: %v", cmdlinePrefix, name, v, err)
+		return def
+	}
+	return i
+}
+
+// cmdlineBool returns the named wolfinit parameter as a boolean, or def if it
+// is absent or malformed. A parameter without a value is treated as true.
+func cmdlineBool(params map[string]string, name string, def bool) bool {
+	v, ok := params[cmdlinePrefix+name]
+	if !ok {
+		return def
+	} else if v == "" {
+		return true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("ignoring malformed %s%s=%q: %v", cmdlinePrefix, name, v, err)
+		return def
+	}
+	return b
+}
+
+// cmdlineDuration returns the named wolfinit parameter as a duration (e.g.
+// 500ms), or def if it is absent or malformed.
+func cmdlineDuration(params map[string]string, name string, def time.Duration) time.Duration {
+	v, ok := params[cmdlinePrefix+name]
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("ignoring malformed %s%s=%q: %v", cmdlinePrefix, name, v, err)
+		return def
+	}
+	return d
+}
+
+// cmdlineEnvPrefix is the prefix (after cmdlinePrefix) of parameters that set
+// entrypoint environment variables, e.g. wolfinit.env.LOG_LEVEL=debug
+const cmdlineEnvPrefix = "env."
+
+// cmdlineRunAs is the key that may be allowed in CmdlineOverrides to let the
+// kernel command line set wolfinit.run_as.
+const cmdlineRunAs = "run-as"
+
+// applyCmdlineRunAs overrides the run-as user with wolfinit.run_as, if the
+// configuration allows it.
+func applyCmdlineRunAs(params map[string]string, ic *ImageConfiguration) {
+	v, ok := params[cmdlinePrefix+"run_as"]
+	if !ok {
+		return
+	}
+	if !slices.Contains(ic.Init.CmdlineOverrides, cmdlineRunAs) {
+		log.Printf("ignoring %srun_as, which is not allowed by cmdline-overrides", cmdlinePrefix)
+		return
+	}
+	log.Printf("using run-as %q from the kernel command line", v)
+	ic.Accounts.RunAs = v
+}
+
+// applyCmdlineEnv sets the environment variables passed as wolfinit.env.KEY
+// parameters, for the keys the configuration allows.
+func applyCmdlineEnv(params map[string]string, ic *ImageConfiguration) {
+	for k, v := range params {
+		key, ok := strings.CutPrefix(k, cmdlinePrefix+cmdlineEnvPrefix)
+		if !ok {
+			continue
+		}
+		if !slices.Contains(ic.Init.CmdlineOverrides, key) {
+			log.Printf("ignoring %s, which is not allowed by cmdline-overrides", k)
+			continue
+		}
+		// Only the key is logged, since values may be sensitive.
+		log.Printf("using %s from the kernel command line", key)
+		ic.Environment[key] = v
+	}
+}
+
+// applyCmdlineEnvPrefixes imports the plain KEY=value parameters (i.e. not
+// wolfinit ones) whose key starts with one of the configured prefixes into the
+// environment. Requiring a prefix keeps the kernel's own parameters out. As
+// with readCmdline, a parameter without a value is imported as empty.
+func applyCmdlineEnvPrefixes(params map[string]string, ic *ImageConfiguration) {
+	var prefixes []string
+	for _, p := range ic.Init.CmdlineEnvPrefixes {
+		if p == "" {
+			log.Printf("ignoring empty cmdline-env-prefixes entry, which would import every parameter")
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	for k, v := range params {
+		if strings.HasPrefix(k, cmdlinePrefix) || !hasAnyPrefix(k, prefixes) {
+			continue
+		}
+		// Only names that are valid in a shell are imported.
+		if !isEnvName(k) {
+			log.Printf("ignoring %s from the kernel command line, which is not a valid variable name", k)
+			continue
+		}
+		log.Printf("using %s from the kernel command line", k)
+		ic.Environment[k] = v
+	}
+}
+
+// hasAnyPrefix returns whether s starts with any of prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEnvName returns whether s is made of letters, digits and underscores, and
+// doesn't start with a digit.
+func isEnvName(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, r := range s {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,918 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ImageEntrypoint struct {
+	// Required: The command of the entrypoint
+	Command string `json:"command,omitempty"`
+}
+
+type ImageAccounts struct {
+	// Required: The user to run the container as. This can be a username or UID.
+	RunAs string `json:"run-as,omitempty" yaml:"run-as"`
+	// Required: List of users to populate the image with
+	Users []User `json:"users,omitempty" yaml:"users"`
+	// Required: List of groups to populate the image with
+	Groups []Group `json:"groups,omitempty" yaml:"groups"`
+}
+
+type Group struct {
+	// Required: The name of the group
+	GroupName string `json:"groupname,omitempty"`
+	// Required: The group ID
+	GID uint32 `json:"gid,omitempty"`
+	// Required: The list of users in this group
+	Members []string `json:"members,omitempty"`
+}
+
+type User struct {
+	// Required: The name of the user
+	UserName string `json:"username,omitempty"`
+	// Required: The user ID
+	UID uint32 `json:"uid,omitempty"`
+	// Required: The user's group ID
+	GID uint32 `json:"gid,omitempty"`
+	// Optional: Environment variables to set when running as this user
+	//
+	// These take precedence over the image's Environment.
+	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+}
+
+type ImageConfiguration struct {
+	// Required: The entrypoint of the container image
+	//
+	// This typically is the path to the executable to run. Since many of
+	// images do not include a shell, this should be the full path
+	// to the executable.
+	Entrypoint ImageEntrypoint `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+
+	// Optional: The command of the container image
+	//
+	// These are the additional arguments to pass to the entrypoint.
+	Cmd string `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+
+	// Optional: The working directory of the container
+	WorkDir string `json:"work-dir,omitempty" yaml:"work-dir,omitempty"`
+
+	// Optional: Account configuration for the container image
+	Accounts ImageAccounts `json:"accounts,omitempty" yaml:"accounts,omitempty"`
+
+	// Optional: Envionment variables to set in the container image
+	//
+	// Values may refer to facts discovered at boot as ${WOLFINIT_HOSTNAME},
+	// ${WOLFINIT_INTERFACE} and ${WOLFINIT_IP}, which are expanded once the
+	// network has been configured.
+	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+
+	// Optional: Settings that control wolfinit itself
+	Init InitConfiguration `json:"wolfinit,omitempty" yaml:"wolfinit,omitempty"`
+}
+
+type InitConfiguration struct {
+	// Optional: The propagation to apply to the root mount
+	//
+	// This is one of shared, slave, private or unbindable, or their recursive
+	// variants (e.g. rshared). Running a container runtime inside the VM
+	// typically requires rshared.
+	RootPropagation string `json:"root-propagation,omitempty" yaml:"root-propagation,omitempty"`
+
+	// Optional: Additional filesystems to mount before the entrypoint is run.
+	// Any of them failing to mount fails boot.
+	Mounts []Mount `json:"mounts,omitempty" yaml:"mounts,omitempty"`
+
+	// Optional: Whether to fail boot on Mounts whose options look invalid
+	// (e.g. an unknown tmpfs option, or size without a value), rather than
+	// only logging the problem and trying anyway
+	StrictMountOptions bool `json:"strict-mount-options,omitempty" yaml:"strict-mount-options,omitempty"`
+
+	// Optional: The path of a unix socket on which to serve init's status
+	//
+	// Each connection is sent a JSON object with the current phase, the
+	// entrypoint's PID and whether it is ready, and is then closed.
+	StatusSocket string `json:"status-socket,omitempty" yaml:"status-socket,omitempty"`
+
+	// Optional: The path of a file to write init's status to, as JSON, each
+	// time it changes
+	//
+	// This holds the same status as StatusSocket, plus the entrypoint's exit
+	// code once it has exited, and is replaced atomically so that it can be
+	// polled (e.g. over a shared mount). Its final phase is "exited".
+	StatusFile string `json:"status-file,omitempty" yaml:"status-file,omitempty"`
+
+	// Optional: The vsock port on which to accept control commands from the
+	// host (status, shutdown and signal <name>)
+	//
+	// The control channel is disabled when this is unset.
+	ControlPort uint32 `json:"control-port,omitempty" yaml:"control-port,omitempty"`
+
+	// Optional: The vsock port on the host to notify when init fails
+	//
+	// A connection is made to the host (CID 2) on this port, and sent a line
+	// of JSON with the "event" ("init-failed"), "category", "exit-code" and
+	// "error", so that the host needn't wait for a timeout. This is
+	// best-effort, and gives up after 2s.
+	FailureNotifyPort uint32 `json:"failure-notify-port,omitempty" yaml:"failure-notify-port,omitempty"`
+
+	// Optional: Where the entrypoint reads its stdin from
+	//
+	// This is either the path of a file (e.g. /dev/null), "inherit" to pass
+	// through init's own stdin for interactive use, or "vsock:<port>" to read
+	// from the first connection the host makes to that vsock port (seeing EOF
+	// once it is closed). By default, stdin is inherited when it is a
+	// terminal and is /dev/null otherwise.
+	Stdin string `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+
+	// Optional: The text of the line printed once the entrypoint has started
+	//
+	// The line is followed by " pid=<pid>", and defaults to
+	// "WOLFINIT: boot-complete".
+	BootMarker string `json:"boot-marker,omitempty" yaml:"boot-marker,omitempty"`
+
+	// Optional: A file to also write the boot-complete line to
+	BootMarkerFile string `json:"boot-marker-file,omitempty" yaml:"boot-marker-file,omitempty"`
+
+	// Optional: The argv[0] to run the entrypoint with
+	//
+	// The binary is still resolved from the first word of the entrypoint, but
+	// the process sees this as its name, e.g. "-sh" for a login shell or the
+	// applet name for busybox-style multi-call binaries.
+	Argv0 string `json:"argv0,omitempty" yaml:"argv0,omitempty"`
+
+	// Optional: A command to run as root before the entrypoint is started
+	//
+	// This runs with the entrypoint's environment and working directory, and
+	// allows setup that needs privileges (e.g. chowning files) while the
+	// entrypoint itself runs as the unprivileged run-as user. If it fails, the
+	// entrypoint is not started.
+	PreStart string `json:"pre-start,omitempty" yaml:"pre-start,omitempty"`
+
+	// Optional: A directory of init scripts to run, in lexical order, before
+	// PreStart and the entrypoint. When this isn't set, /etc/wolfinit/init.d
+	// is used if it exists.
+	//
+	// Like PreStart, these run as root with the entrypoint's environment and
+	// working directory. Non-executable files are skipped.
+	InitScripts string `json:"init-scripts,omitempty" yaml:"init-scripts,omitempty"`
+
+	// Optional: Whether an init script failing is fatal, rather than logged
+	InitScriptsFatal bool `json:"init-scripts-fatal,omitempty" yaml:"init-scripts-fatal,omitempty"`
+
+	// Optional: The settings the kernel command line may override
+	//
+	// Entries are either "run-as", allowing wolfinit.run_as=<user>, or the
+	// name of an environment variable KEY, allowing wolfinit.env.KEY=<value>.
+	// Nothing may be overridden by default. Allowed overrides take precedence
+	// over everything in this file, including per-user environment.
+	CmdlineOverrides []string `json:"cmdline-overrides,omitempty" yaml:"cmdline-overrides,omitempty"`
+
+	// Optional: Prefixes of plain kernel command line parameters to import
+	// into the entrypoint's environment, e.g. "APP_" imports APP_MODE=prod
+	//
+	// Parameters with the wolfinit. prefix are never imported, and neither
+	// are names that aren't valid variable names. These take precedence over
+	// everything in this file, but not over CmdlineOverrides.
+	CmdlineEnvPrefixes []string `json:"cmdline-env-prefixes,omitempty" yaml:"cmdline-env-prefixes,omitempty"`
+
+	// Optional: What to do when no interface obtains a DHCP lease
+	//
+	// This is one of "continue" (the default) to start the entrypoint without
+	// networking, "retry" to try again with exponential backoff (up to 5 more
+	// times) before continuing, or "fatal" to power off the VM.
+	DHCPFailure string `json:"dhcp-failure,omitempty" yaml:"dhcp-failure,omitempty"`
+
+	// Optional: What to do when interfaces obtain DHCP leases, but none of
+	// them can be configured with theirs (e.g. its address can't be added)
+	//
+	// This takes the same values as DHCPFailure, and also defaults to
+	// "continue", which starts the entrypoint with whatever did get
+	// configured. Some interfaces failing while others succeed is only
+	// logged.
+	DHCPConfigureFailure string `json:"dhcp-configure-failure,omitempty" yaml:"dhcp-configure-failure,omitempty"`
+
+	// Optional: Pet the hypervisor's watchdog device from init, so that the
+	// VM is reset if init hangs. It is left alone by default.
+	//
+	// While the entrypoint runs, it is only petted as long as init's
+	// supervision loop keeps checking in, so that a hang there resets the VM
+	// too. A missing device is only logged. The watchdog is disarmed as init
+	// powers off, where the driver allows that.
+	HardwareWatchdog *HardwareWatchdog `json:"hardware-watchdog,omitempty" yaml:"hardware-watchdog,omitempty"`
+
+	// Optional: Whether to re-execute init in a new mount namespace before
+	// making the configured mounts (default false)
+	//
+	// This is only needed when wolfinit isn't PID 1 (e.g. when testing it in
+	// a container or on a host), so that its mounts don't change a mount
+	// table shared with the rest of the system. As PID 1 it does nothing.
+	// This happens before anything is mounted, so /proc, /dev, /sys and the
+	// cgroup hierarchy are only mounted in the new namespace too.
+	MountNamespace bool `json:"mount-namespace,omitempty" yaml:"mount-namespace,omitempty"`
+
+	// Optional: The most interfaces to request DHCP leases for at once, when
+	// configuring several of them (default all at once)
+	DHCPConcurrency int `json:"dhcp-concurrency,omitempty" yaml:"dhcp-concurrency,omitempty"`
+
+	// Optional: Whether to keep the nameservers from DHCP in /dev, bind
+	// mounted over /etc/resolv.conf, when /etc is read-only (default false)
+	//
+	// The image's /etc/resolv.conf must exist for this to work.
+	ResolvConfFallback bool `json:"resolv-conf-fallback,omitempty" yaml:"resolv-conf-fallback,omitempty"`
+
+	// Optional: Whether to run the entrypoint as the leader of a new session
+	//
+	// When stdin is a terminal, it also becomes the session's controlling
+	// terminal. Since a session leader is typically responsible for its
+	// children, signals forwarded to the entrypoint (on termination, or via
+	// the control channel) are then sent to its whole process group.
+	Setsid bool `json:"setsid,omitempty" yaml:"setsid,omitempty"`
+
+	// Optional: The soft limit on open files (RLIMIT_NOFILE) for the
+	// entrypoint
+	//
+	// This only applies to the entrypoint, not to init itself, and is clamped
+	// to the hard limit.
+	NoFile uint64 `json:"nofile,omitempty" yaml:"nofile,omitempty"`
+
+	// Optional: Where to write core dumps of the entrypoint when it crashes,
+	// for debugging. By default the kernel's core settings are left alone.
+	//
+	// This lifts the entrypoint's core size limit, and sets the kernel's
+	// core_pattern, which applies to every process in the VM.
+	CoreDumps *CoreDumps `json:"core-dumps,omitempty" yaml:"core-dumps,omitempty"`
+
+	// Optional: The only capabilities the entrypoint may have (e.g.
+	// "CAP_NET_BIND_SERVICE"), with every other one dropped from its bounding
+	// set. An empty list drops them all, while leaving this unset keeps them.
+	//
+	// These are dropped as the entrypoint starts, so init keeps the
+	// capabilities it needs for setup (e.g. for DHCP), as do the PreStart
+	// command and init scripts. A non-root entrypoint is given these as
+	// ambient capabilities.
+	Capabilities []string `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+
+	// Optional: A setpriv-style spec of the privileges to run the entrypoint
+	// with, all applied together as it starts
+	//
+	// Its UID and GID take precedence over the run-as user, its Groups over
+	// the run-as user's supplementary groups, and its Capabilities are used
+	// instead of Capabilities above (which must then be unset).
+	Privileges *Privileges `json:"privileges,omitempty" yaml:"privileges,omitempty"`
+
+	// Optional: Signals to block or unblock in the mask the entrypoint starts
+	// with, by name (e.g. "SIGPIPE"), since some daemons expect certain
+	// signals to be blocked. The mask is unchanged by default.
+	//
+	// The mask is kept across exec, so a signal blocked here stays pending
+	// when init forwards it (e.g. SIGTERM on termination, or via the control
+	// channel) until the entrypoint unblocks it. SIGKILL and SIGSTOP can't be
+	// blocked, so KillAfter still works.
+	SignalMask *SignalMask `json:"signal-mask,omitempty" yaml:"signal-mask,omitempty"`
+
+	// Optional: Existing namespaces to start the entrypoint in, by path (e.g.
+	// "/proc/123/ns/net" of a process that created them, or a bind mount of
+	// one), like nsenter
+	//
+	// Any of the cgroup, ipc, net, pid and uts namespaces can be entered,
+	// but not mount, time or user namespaces, which the kernel only lets a
+	// single-threaded process join. Only the entrypoint enters them.
+	EnterNamespaces []string `json:"enter-namespaces,omitempty" yaml:"enter-namespaces,omitempty"`
+
+	// Optional: Start the entrypoint in a network namespace of its own, with
+	// an interface moved into it from init's, to isolate the workload's
+	// network. By default the entrypoint shares init's network.
+	//
+	// Init still configures its own interfaces first, and the interface to
+	// move must not be one of them (so set Interface or InterfaceMAC when the
+	// VM has several). Once moved, the interface is only configured
+	// statically, as given here: DHCP, Neighbors and the like only apply to
+	// init's interfaces. This can't be combined with entering an existing
+	// network namespace with EnterNamespaces.
+	NetworkNamespace *NetworkNamespace `json:"network-namespace,omitempty" yaml:"network-namespace,omitempty"`
+
+	// Optional: Run the entrypoint in a new user namespace with these ID
+	// mappings, e.g. so that it runs as root inside the namespace while the
+	// run-as user is unprivileged outside of it
+	//
+	// The run-as user and group are IDs outside the namespace, and must be
+	// mapped. This only applies to the entrypoint, so init, the PreStart
+	// command, init scripts and services are unaffected. Inside the
+	// namespace, files owned by unmapped IDs appear to be owned by nobody,
+	// and capabilities only apply to what the namespace owns (so e.g. the
+	// entrypoint can't mount block devices or change the network).
+	UserNamespace *UserNamespace `json:"user-namespace,omitempty" yaml:"user-namespace,omitempty"`
+
+	// Optional: The AppArmor profile to confine the entrypoint to, which must
+	// already be loaded. This is skipped when AppArmor isn't enabled.
+	AppArmorProfile string `json:"apparmor-profile,omitempty" yaml:"apparmor-profile,omitempty"`
+
+	// Optional: The SELinux context to run the entrypoint in (e.g.
+	// "system_u:system_r:container_t:s0"), which must be valid in the loaded
+	// policy. This is skipped when SELinux isn't enabled.
+	SELinuxContext string `json:"selinux-context,omitempty" yaml:"selinux-context,omitempty"`
+
+	// Optional: How many times to retry bringing the network interface up
+	// before giving up (default 3)
+	LinkUpRetries *int `json:"link-up-retries,omitempty" yaml:"link-up-retries,omitempty"`
+
+	// Optional: The name of the network interface to configure
+	Interface string `json:"interface,omitempty" yaml:"interface,omitempty"`
+
+	// Optional: The MAC address of the network interface to configure, which
+	// is more stable than its name
+	//
+	// This takes precedence over Interface. When neither matches an
+	// interface, the first one supporting broadcast and multicast is used
+	// (or all of them, with AllInterfaces).
+	InterfaceMAC string `json:"interface-mac,omitempty" yaml:"interface-mac,omitempty"`
+
+	// Optional: How long to wait for the Interface or InterfaceMAC to appear,
+	// for NICs that are registered shortly after init starts (default 0,
+	// which doesn't wait). If it doesn't show up in time, the interface is
+	// detected as though neither was set.
+	InterfaceTimeout Duration `json:"interface-timeout,omitempty" yaml:"interface-timeout,omitempty"`
+
+	// Optional: Whether to configure every interface that supports broadcast
+	// and multicast via DHCP, rather than only the first
+	AllInterfaces bool `json:"all-interfaces,omitempty" yaml:"all-interfaces,omitempty"`
+
+	// Optional: Whether to disable IPv6 on the interfaces that are
+	// configured, for workloads that misbehave when it is present (default
+	// false, which leaves it as the kernel set it up)
+	DisableIPv6 bool `json:"disable-ipv6,omitempty" yaml:"disable-ipv6,omitempty"`
+
+	// Optional: The interface whose default route is preferred, when more
+	// than one interface obtains a lease
+	//
+	// By default, the first leased interface by name is preferred. The others
+	// get default routes with higher metrics, in name order.
+	PrimaryInterface string `json:"primary-interface,omitempty" yaml:"primary-interface,omitempty"`
+
+	// Optional: Static neighbor (ARP) entries to install once the network
+	// interfaces are up, e.g. for point-to-point networks without ARP
+	Neighbors []Neighbor `json:"neighbors,omitempty" yaml:"neighbors,omitempty"`
+
+	// Optional: How to back /tmp, which is a tmpfs by default
+	Tmp TmpConfig `json:"tmp,omitempty" yaml:"tmp,omitempty"`
+
+	// Optional: Whether to mount hugetlbfs, and reserve huge pages, e.g. for
+	// databases and DPDK
+	HugePages *HugePages `json:"hugepages,omitempty" yaml:"hugepages,omitempty"`
+
+	// Optional: Resource limits to place the entrypoint under, using a
+	// dedicated cgroup
+	Resources Resources `json:"resources,omitempty" yaml:"resources,omitempty"`
+
+	// Optional: What to do when the entrypoint exits unsuccessfully
+	//
+	// When the entrypoint exits with status 0, the VM is always powered off.
+	// Otherwise (a non-zero status, or being killed by a signal) this is one
+	// of:
+	//   - "poweroff" (the default): power off the VM.
+	//   - "keepalive": keep the VM running until init is asked to stop.
+	//   - "shell": start a root shell on the console, and power off once it
+	//     exits.
+	//   - "restart": start the entrypoint again.
+	OnFailure string `json:"on-failure,omitempty" yaml:"on-failure,omitempty"`
+
+	// Optional: With OnFailure "restart", only restart the entrypoint when it
+	// exits with one of these codes (e.g. one it uses to ask to be restarted
+	// after updating itself), and power off the VM otherwise. By default any
+	// unsuccessful exit restarts it.
+	//
+	// Being killed by a signal counts as exiting with 128 plus its number,
+	// as in a shell.
+	RestartExitCodes []int `json:"restart-exit-codes,omitempty" yaml:"restart-exit-codes,omitempty"`
+
+	// Optional: A file to write init's own logs to, in addition to the
+	// console (e.g. /var/log/wolfinit.log on one of the Mounts)
+	//
+	// Only messages logged after the mounts are set up are written to it.
+	LogFile string `json:"log-file,omitempty" yaml:"log-file,omitempty"`
+
+	// Optional: The size in bytes past which LogFile is rotated to
+	// LogFile.1 at boot (default 10MiB)
+	LogFileMaxSize int64 `json:"log-file-max-size,omitempty" yaml:"log-file-max-size,omitempty"`
+
+	// Optional: A file of extra arguments to append to the entrypoint and cmd
+	//
+	// The contents are split like a shell command line (e.g. one argument
+	// per line, quoting any that contain spaces, and # comments). The file
+	// must exist when this is set.
+	ArgsFile string `json:"args-file,omitempty" yaml:"args-file,omitempty"`
+
+	// Optional: How long after forwarding SIGTERM to the entrypoint (when
+	// init is asked to stop) to send it SIGKILL (default 10s)
+	//
+	// A value of 0 disables the escalation, waiting for the entrypoint to
+	// exit however long it takes.
+	//
+	// When its output is prefixed or sent to syslog, this is also how long
+	// to keep copying it once the entrypoint has exited, for anything it
+	// left running (e.g. after daemonizing) that still holds it.
+	KillAfter *Duration `json:"kill-after,omitempty" yaml:"kill-after,omitempty"`
+
+	// Optional: Whether to supervise the entrypoint as a long-running service
+	//
+	// Rather than powering off when the entrypoint exits, it is restarted
+	// (whatever its exit status), and the VM is only powered off when init
+	// is asked to stop, via SIGTERM or the control channel's shutdown
+	// command. This takes precedence over OnFailure.
+	Supervise bool `json:"supervise,omitempty" yaml:"supervise,omitempty"`
+
+	// Optional: An interpreter to run the entrypoint with (e.g. "python3" or
+	// "sh -e"), which is prepended to its arguments and resolved on the PATH
+	//
+	// This is for scripts without a shebang or the executable bit. When set,
+	// the interpreter is used even if the script has a shebang.
+	Interpreter string `json:"interpreter,omitempty" yaml:"interpreter,omitempty"`
+
+	// Optional: What to do when the entrypoint exists, but isn't executable
+	//
+	// This is one of "fail" (the default), which powers off the VM with a
+	// message saying how to fix the image, or "chmod" to mark it executable.
+	// It doesn't apply with an Interpreter, which runs the entrypoint itself.
+	NonExecutableEntrypoint string `json:"non-executable-entrypoint,omitempty" yaml:"non-executable-entrypoint,omitempty"`
+
+	// Optional: A shell (e.g. "/bin/bash"), resolved on the PATH, to start
+	// the entrypoint from as a login shell, so that /etc/profile and the
+	// like are sourced first (like docker run --entrypoint bash -l). By
+	// default the entrypoint is executed directly.
+	//
+	// The shell replaces itself with the entrypoint, which is passed its
+	// arguments exactly as they were split from the configuration, rather
+	// than the shell parsing them again. So quotes work as they otherwise do,
+	// and e.g. $VARIABLES and pipes are not interpreted by the shell (use
+	// Interpreter "sh -c" for that). Argv0 is ignored.
+	LoginShell string `json:"login-shell,omitempty" yaml:"login-shell,omitempty"`
+
+	// Optional: Other services to run alongside the entrypoint, with its
+	// environment, working directory and run-as user
+	//
+	// Services are restarted whenever they exit, and asked to exit with
+	// SIGTERM once the VM is shutting down. When one of them is marked main,
+	// its exit (rather than the entrypoint's) powers off the VM, and the
+	// entrypoint is supervised like the other services.
+	Services []Service `json:"services,omitempty" yaml:"services,omitempty"`
+
+	// Optional: Whether the entrypoint is a oneshot job (e.g. a CI task)
+	//
+	// The VM is powered off cleanly when it succeeds, and when it fails
+	// OnFailure applies, which then defaults to "keepalive" so that it can be
+	// inspected. This cannot be combined with Supervise, a main service, or
+	// OnFailure "restart".
+	Oneshot bool `json:"oneshot,omitempty" yaml:"oneshot,omitempty"`
+
+	// Optional: Exponential backoff, with jitter, between restarts of the
+	// entrypoint (whether supervised or restarted by OnFailure)
+	//
+	// Without this, a supervised entrypoint is restarted after 1s, and one
+	// restarted on failure is restarted at once.
+	RestartBackoff *Backoff `json:"restart-backoff,omitempty" yaml:"restart-backoff,omitempty"`
+
+	// Optional: The path of a named pipe, created for the run-as user, that
+	// a daemonizing entrypoint writes its final exit status to
+	//
+	// When the entrypoint exits successfully (e.g. once it has forked into
+	// the background), its exit is only considered to have happened once a
+	// line holding the real exit status (e.g. "0") is written to the pipe.
+	// An unsuccessful exit is taken as is.
+	ExitFifo string `json:"exit-fifo,omitempty" yaml:"exit-fifo,omitempty"`
+
+	// Optional: How long to wait after the entrypoint exits before powering
+	// off, so that log shippers (e.g. in Services) can catch up (default 0)
+	//
+	// Receiving SIGTERM or SIGINT during the delay powers off at once.
+	ShutdownDelay Duration `json:"shutdown-delay,omitempty" yaml:"shutdown-delay,omitempty"`
+
+	// Optional: Whether to launch the entrypoint with a raw fork and exec,
+	// and wait for it directly, rather than through Go's os/exec
+	//
+	// This avoids os/exec's wait goroutine, which otherwise has to be
+	// coordinated with reaping orphaned processes. The entrypoint's stdio is
+	// passed straight through, and signals are forwarded as usual.
+	ForkExec bool `json:"fork-exec,omitempty" yaml:"fork-exec,omitempty"`
+
+	// Optional: A tag to start each line of the entrypoint's stdout and
+	// stderr with (e.g. "[app] "), to tell its output apart from init's on a
+	// shared console. Its output is passed through unchanged by default.
+	//
+	// This can't be combined with ForkExec, which passes the entrypoint our
+	// stdio directly.
+	OutputPrefix string `json:"output-prefix,omitempty" yaml:"output-prefix,omitempty"`
+
+	// Optional: A Go time layout (e.g. "2006-01-02T15:04:05.000Z07:00") to
+	// start each line of the entrypoint's stdout and stderr with the time
+	// in, ahead of any OutputPrefix. Lines aren't timestamped by default.
+	//
+	// As with OutputPrefix, this can't be combined with ForkExec. Neither is
+	// applied when the entrypoint's stdin is a terminal (since it is then
+	// interactive), and both stop once its output looks binary (contains a
+	// NUL byte).
+	OutputTimestamp string `json:"output-timestamp,omitempty" yaml:"output-timestamp,omitempty"`
+
+	// Optional: Send each line of the entrypoint's stdout and stderr to the
+	// image's syslog daemon on /dev/log, rather than to the console
+	//
+	// The daemon may start after init (e.g. as a service): until /dev/log can
+	// be connected to, output still goes to the console. As with
+	// OutputPrefix, this can't be combined with ForkExec.
+	Syslog *Syslog `json:"syslog,omitempty" yaml:"syslog,omitempty"`
+
+	// Optional: The locale to set LANG to, unless the Environment sets it
+	// (default C.UTF-8)
+	Locale string `json:"locale,omitempty" yaml:"locale,omitempty"`
+
+	// Optional: Whether to run locale-gen (if installed) for the locale
+	// before starting the entrypoint
+	LocaleGen bool `json:"locale-gen,omitempty" yaml:"locale-gen,omitempty"`
+
+	// Optional: Whether to create /dev/console if devtmpfs didn't
+	Console bool `json:"console,omitempty" yaml:"console,omitempty"`
+
+	// Optional: Whether to point init's stdio, and so the entrypoint's, at
+	// /dev/console (requires Console)
+	ConsoleStdio bool `json:"console-stdio,omitempty" yaml:"console-stdio,omitempty"`
+
+	// Optional: Whether to create the /dev/fd, /dev/stdin, /dev/stdout and
+	// /dev/stderr symlinks into /proc/self/fd, which some programs rely on
+	// (e.g. for process substitution), when they are missing (default false)
+	DevLinks bool `json:"dev-links,omitempty" yaml:"dev-links,omitempty"`
+
+	// Optional: Conditions that must hold before the entrypoint is started,
+	// which are waited for in order
+	WaitFor []WaitFor `json:"wait-for,omitempty" yaml:"wait-for,omitempty"`
+
+	// Optional: What to do when a WaitFor condition times out, either
+	// "proceed" (the default) or "fatal" to power off the VM
+	WaitForTimeout string `json:"wait-for-timeout,omitempty" yaml:"wait-for-timeout,omitempty"`
+
+	// Optional: What to do when the run-as user is neither one of the
+	// configured users nor a numeric UID
+	//
+	// This is one of "fail" (the default), "root" to run as root anyway, or
+	// "create" to add the user to /etc/passwd with the next free UID.
+	MissingRunAs string `json:"missing-run-as,omitempty" yaml:"missing-run-as,omitempty"`
+
+	// Optional: What to do when the run-as user matches more than one of the
+	// configured users, by name or UID
+	//
+	// This is one of "first" (the default) to use the first one listed,
+	// "lowest-uid" to use the one with the lowest UID, or "fail".
+	DuplicateRunAs string `json:"duplicate-run-as,omitempty" yaml:"duplicate-run-as,omitempty"`
+
+	// Optional: What to do when the WorkDir doesn't exist, once every mount is
+	// in place
+	//
+	// This is one of "fail" (the default), "create" to create it (owned by
+	// the run-as user), or "root" to run in / instead.
+	MissingWorkDir string `json:"missing-work-dir,omitempty" yaml:"missing-work-dir,omitempty"`
+
+	// Optional: Debugging by running the entrypoint under strace, if it is
+	// installed, which slows it down considerably
+	//
+	// The entrypoint's children are traced too. Argv0 is not applied while
+	// tracing.
+	Strace *Strace `json:"strace,omitempty" yaml:"strace,omitempty"`
+
+	// Optional: The proxies for the entrypoint to use, which set HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY (and their lower case spellings) unless the
+	// Environment sets them
+	Proxy *Proxy `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+
+	// Optional: The names of variables to pass through from init's own
+	// environment to the entrypoint's, when they are set (e.g. HTTP_PROXY
+	// when checking a configuration locally)
+	//
+	// Nothing is passed through by default. These take precedence over the
+	// Environment (including the run-as user's), but not over the kernel
+	// command line.
+	PassEnvironment []string `json:"pass-environment,omitempty" yaml:"pass-environment,omitempty"`
+
+	// Optional: What to do when the Environment sets PATH to the empty string,
+	// which finds no commands at all
+	//
+	// This is one of "default" (the default), which uses the default PATH as
+	// though it wasn't set, or "keep" to keep it empty.
+	EmptyPath string `json:"empty-path,omitempty" yaml:"empty-path,omitempty"`
+
+	// Optional: Whether to start the entrypoint with only the variables named
+	// in AllowEnvironment (default false, which passes the whole resolved
+	// environment)
+	//
+	// Each allowed variable takes its value, in order of precedence, from the
+	// kernel command line, PassEnvironment, the Environment (including the
+	// run-as user's), init's defaults (e.g. PATH), and lastly init's own
+	// environment. Unless the entrypoint is an absolute path, PATH should be
+	// allowed so that it can be found.
+	CleanEnvironment bool `json:"clean-environment,omitempty" yaml:"clean-environment,omitempty"`
+
+	// Optional: The names of the variables to keep with CleanEnvironment
+	AllowEnvironment []string `json:"allow-environment,omitempty" yaml:"allow-environment,omitempty"`
+
+	// Optional: What to do with environment variables that can't be passed
+	// to the entrypoint, because the name is empty or contains "=", or either
+	// contains a NUL byte
+	//
+	// This is one of "fail" (the default), or "drop" to leave them out.
+	InvalidEnvironment string `json:"invalid-environment,omitempty" yaml:"invalid-environment,omitempty"`
+
+	// Optional: The name init shows up as in ps and top (e.g.
+	// "init-db-primary"), which the kernel truncates to 15 bytes
+	ProcessTitle string `json:"process-title,omitempty" yaml:"process-title,omitempty"`
+
+	// Optional: The machine ID to write to /etc/machine-id (and D-Bus's
+	// copy), as 32 lower case hex digits, or "generate" for a random one
+	// unless the image has one
+	//
+	// When /etc is read-only, the ID is mounted over the image's file.
+	MachineID string `json:"machine-id,omitempty" yaml:"machine-id,omitempty"`
+
+	// Optional: Paths whose ownership to change before the entrypoint starts,
+	// e.g. directories the run-as user must write to
+	Chown []ChownSpec `json:"chown,omitempty" yaml:"chown,omitempty"`
+
+	// Optional: Paths whose mode to change before the entrypoint starts, after
+	// any Chown
+	//
+	// Paths that don't exist are logged and skipped, while an invalid mode is
+	// a configuration error.
+	Chmod []ChmodSpec `json:"chmod,omitempty" yaml:"chmod,omitempty"`
+
+	// Optional: CA certificates to add to the system trust store before the
+	// entrypoint starts, each either the path of a PEM file or inline PEM
+	//
+	// If the image has update-ca-certificates they are installed with it, and
+	// otherwise appended to /etc/ssl/certs/ca-certificates.crt.
+	CACertificates []string `json:"ca-certificates,omitempty" yaml:"ca-certificates,omitempty"`
+
+	// Optional: Whether to skip reaping orphaned processes
+	//
+	// Orphans are re-parented to init, and left as zombies when it doesn't
+	// reap them. This is only safe when the entrypoint never leaves orphans,
+	// or collects them itself (e.g. as a child subreaper, like tini -s, or
+	// as PID 1 of its own PID namespace).
+	DisableReaper bool `json:"disable-reaper,omitempty" yaml:"disable-reaper,omitempty"`
+
+	// Optional: The most orphaned processes to reap at a time (default 256),
+	// or 0 for no limit
+	//
+	// Every exited process is still reaped, but in batches, so that a burst
+	// of them doesn't hold up starting other processes.
+	ReapBatch *int `json:"reap-batch,omitempty" yaml:"reap-batch,omitempty"`
+
+	// Optional: How often to log memory usage and the disk usage of
+	// PressureMounts while the entrypoint runs. Sampling is off by default.
+	PressureInterval Duration `json:"pressure-interval,omitempty" yaml:"pressure-interval,omitempty"`
+
+	// Optional: The mounts whose disk usage is sampled, which defaults to /
+	// and /tmp
+	PressureMounts []string `json:"pressure-mounts,omitempty" yaml:"pressure-mounts,omitempty"`
+}
+
+type WaitFor struct {
+	// Optional: A path that must exist
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// Optional: A host:port that must accept TCP connections
+	TCP string `json:"tcp,omitempty" yaml:"tcp,omitempty"`
+	// Optional: A command that must exit with status 0
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Optional: A name that must resolve, to check that DNS works
+	DNS string `json:"dns,omitempty" yaml:"dns,omitempty"`
+	// Optional: How long to wait for the condition (default 30s)
+	Timeout *Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+type Service struct {
+	// Required: A name for the service, used in logs
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Required: The command line to run
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Optional: Whether this is the main process, whose exit powers off the
+	// VM. At most one service may be the main one.
+	Main bool `json:"main,omitempty" yaml:"main,omitempty"`
+}
+
+type Backoff struct {
+	// Optional: The delay before the first restart (default 1s)
+	Base *Duration `json:"base,omitempty" yaml:"base,omitempty"`
+	// Optional: The longest delay between restarts (default 1m)
+	Max *Duration `json:"max,omitempty" yaml:"max,omitempty"`
+	// Optional: How much the delay grows by after each restart (default 2)
+	Multiplier float64 `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	// Optional: How long the entrypoint must stay up for the delay to go
+	// back to Base (default 1m)
+	ResetAfter *Duration `json:"reset-after,omitempty" yaml:"reset-after,omitempty"`
+}
+
+type HardwareWatchdog struct {
+	// Optional: The watchdog device (default /dev/watchdog)
+	Device string `json:"device,omitempty" yaml:"device,omitempty"`
+	// Optional: How often to pet it (default half of its timeout, or 10s if
+	// that can't be read)
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+}
+
+type CoreDumps struct {
+	// Required: The absolute path of the directory to write cores to, which
+	// is created if missing
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// Optional: The file name for cores, with the % specifiers of core(5)
+	// (default "core.%e.%p")
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+}
+
+type Syslog struct {
+	// Optional: The facility to log with (default "user"), e.g. "daemon" or
+	// "local0"
+	Facility string `json:"facility,omitempty" yaml:"facility,omitempty"`
+	// Optional: The severity to log with (default "info"), e.g. "notice"
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty"`
+	// Optional: The tag to log with (default "entrypoint")
+	Tag string `json:"tag,omitempty" yaml:"tag,omitempty"`
+}
+
+type Privileges struct {
+	// Optional: The user to run as
+	UID *int `json:"uid,omitempty" yaml:"uid,omitempty"`
+	// Optional: The group to run as
+	GID *int `json:"gid,omitempty" yaml:"gid,omitempty"`
+	// Optional: The supplementary groups, where an empty list clears them
+	Groups []int `json:"groups,omitempty" yaml:"groups,omitempty"`
+	// Optional: The only capabilities to keep, as for Capabilities above
+	Capabilities []string `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
+	// Optional: Whether to set no_new_privs, so that exec can't gain
+	// privileges (e.g. through setuid binaries)
+	//
+	// This also restricts which AppArmor and SELinux transitions exec may
+	// make.
+	NoNewPrivs bool `json:"no-new-privs,omitempty" yaml:"no-new-privs,omitempty"`
+	// Optional: The securebits to set (e.g. "noroot", "noroot_locked"), see
+	// capabilities(7)
+	Securebits []string `json:"securebits,omitempty" yaml:"securebits,omitempty"`
+}
+
+type SignalMask struct {
+	// Optional: The signals to block
+	Block []string `json:"block,omitempty" yaml:"block,omitempty"`
+	// Optional: The signals to unblock
+	Unblock []string `json:"unblock,omitempty" yaml:"unblock,omitempty"`
+}
+
+type NetworkNamespace struct {
+	// Required: The name of the interface (e.g. a second NIC, or one end of a
+	// veth pair) to move into the namespace
+	Interface string `json:"interface,omitempty" yaml:"interface,omitempty"`
+	// Optional: The address to give it, in CIDR notation (e.g.
+	// "10.0.0.2/24")
+	Address string `json:"address,omitempty" yaml:"address,omitempty"`
+	// Optional: The gateway for the namespace's default route
+	Gateway string `json:"gateway,omitempty" yaml:"gateway,omitempty"`
+}
+
+type UserNamespace struct {
+	// Required: The user IDs to map into the namespace
+	UIDMappings []IDMapping `json:"uid-mappings,omitempty" yaml:"uid-mappings,omitempty"`
+	// Required: The group IDs to map into the namespace
+	GIDMappings []IDMapping `json:"gid-mappings,omitempty" yaml:"gid-mappings,omitempty"`
+}
+
+type IDMapping struct {
+	// Required: The first ID inside the namespace
+	ContainerID int `json:"container-id" yaml:"container-id"`
+	// Required: The first ID outside the namespace that it maps to
+	HostID int `json:"host-id" yaml:"host-id"`
+	// Required: How many consecutive IDs to map
+	Size int `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+type ChownSpec struct {
+	// Required: The path to change the ownership of
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Optional: The owner (default the run-as user)
+	UID *int `json:"uid,omitempty" yaml:"uid,omitempty"`
+	// Optional: The group (default the run-as user's group)
+	GID *int `json:"gid,omitempty" yaml:"gid,omitempty"`
+	// Optional: Whether to change everything under the path too
+	Recursive bool `json:"recursive,omitempty" yaml:"recursive,omitempty"`
+}
+
+type ChmodSpec struct {
+	// Required: The path to change the mode of
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Required: The mode, in octal (e.g. "0750", or "1777" for a sticky
+	// directory)
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+}
+
+type Strace struct {
+	// Optional: Extra options for strace, e.g. "-e trace=file,process"
+	Options string `json:"options,omitempty" yaml:"options,omitempty"`
+	// Optional: Where to write the trace (default /tmp/wolfinit.strace),
+	// which should be on a persistent mount to outlive the VM
+	Output string `json:"output,omitempty" yaml:"output,omitempty"`
+}
+
+type Proxy struct {
+	// Optional: The proxy for HTTP requests, e.g. "http://proxy.corp:3128"
+	// (the scheme defaults to http)
+	HTTP string `json:"http,omitempty" yaml:"http,omitempty"`
+	// Optional: The proxy for HTTPS requests
+	HTTPS string `json:"https,omitempty" yaml:"https,omitempty"`
+	// Optional: Hosts, domains and CIDRs to reach directly
+	NoProxy []string `json:"no-proxy,omitempty" yaml:"no-proxy,omitempty"`
+}
+
+type HugePages struct {
+	// Optional: Where to mount hugetlbfs (default /dev/hugepages)
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Optional: How many huge pages of the default size to reserve, via
+	// /proc/sys/vm/nr_hugepages
+	Reserve int64 `json:"reserve,omitempty" yaml:"reserve,omitempty"`
+}
+
+type Resources struct {
+	// Optional: The memory limit, in bytes or with a K, M or G suffix. With
+	// cgroup v2, processes being OOM killed under it are logged.
+	Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+	// Optional: The number of CPUs worth of time the entrypoint may use
+	// (e.g. 1.5)
+	CPUs float64 `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	// Optional: The maximum number of processes
+	Pids int64 `json:"pids,omitempty" yaml:"pids,omitempty"`
+}
+
+type Neighbor struct {
+	// Required: The IP address of the neighbor
+	IP string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	// Required: The MAC address of the neighbor
+	MAC string `json:"mac,omitempty" yaml:"mac,omitempty"`
+	// Optional: The interface the neighbor is on (default the first
+	// configured interface)
+	Interface string `json:"interface,omitempty" yaml:"interface,omitempty"`
+}
+
+type TmpConfig struct {
+	// Optional: A block device to mount on /tmp instead of a tmpfs
+	Device string `json:"device,omitempty" yaml:"device,omitempty"`
+	// Optional: The filesystem type of the device (default ext4)
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Optional: Whether to format the device with mkfs.<type> when it is
+	// blank (all zeroes)
+	Format bool `json:"format,omitempty" yaml:"format,omitempty"`
+	// Optional: Whether to check the device with fsck.<type> before
+	// mounting it, falling back to a tmpfs if it has errors that can't be
+	// fixed automatically
+	Fsck bool `json:"fsck,omitempty" yaml:"fsck,omitempty"`
+}
+
+type Mount struct {
+	// Required: The device (or pseudo-filesystem name) to mount
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	// Required: The directory to mount onto, which is created if missing
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+	// Required: The filesystem type (e.g. ext4, tmpfs)
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Optional: Comma-separated mount options, as passed to mount -o
+	Options string `json:"options,omitempty" yaml:"options,omitempty"`
+	// Optional: The propagation to apply to the mount once it is mounted,
+	// accepting the same values as RootPropagation.
+	Propagation string `json:"propagation,omitempty" yaml:"propagation,omitempty"`
+	// Optional: Where this comes in the order of mounting, lowest first
+	// (default 0). Mounts with the same order are mounted parents first (e.g.
+	// /a before /a/b), and otherwise in the order they are listed.
+	Order int `json:"order,omitempty" yaml:"order,omitempty"`
+	// Optional: Whether to check the source block device with fsck.<type>
+	// (found on the PATH) before mounting it, which is skipped with a log
+	// line if the image has no such fsck. Boot fails if it has errors that
+	// can't be fixed automatically.
+	Fsck bool `json:"fsck,omitempty" yaml:"fsck,omitempty"`
+}
+
+// Duration is a time.Duration that is written as a string, e.g. "1m30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
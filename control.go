@@ -0,0 +1,174 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// serveControl listens on the given vsock port for line-oriented commands
+// from the host. The supported commands are:
+//
+//	status         - reply with the current status as JSON
+//	shutdown       - ask the entrypoint (or init, if it hasn't started) to stop
+//	signal <name>  - send the named signal (e.g. SIGHUP) to the entrypoint
+//
+// The returned function stops the listener.
+func serveControl(port uint32, requestShutdown func()) (func(), error) {
+	fd, err := listenVsock(port)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			nfd, _, err := unix.Accept4(fd, unix.SOCK_CLOEXEC)
+			if err == unix.EINTR {
+				continue
+			} else if err != nil {
+				// This is how we expect to exit when the listener is stopped.
+				return
+			}
+			go handleControl(os.NewFile(uintptr(nfd), "vsock"), requestShutdown)
+		}
+	}()
+	return func() {
+		// Shutting down the socket unblocks the pending accept.
+		unix.Shutdown(fd, unix.SHUT_RDWR)
+		unix.Close(fd)
+	}, nil
+}
+
+// listenVsock returns a socket listening on the given vsock port.
+func listenVsock(port uint32) (int, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("creating vsock socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_ANY, Port: port}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("binding vsock port %d: %w", port, err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("listening on vsock port %d: %w", port, err)
+	}
+	return fd, nil
+}
+
+// handleControl processes commands from a single control connection until
+// the host closes it.
+func handleControl(conn io.ReadWriteCloser, requestShutdown func()) {
+	defer conn.Close()
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var reply string
+		switch fields[0] {
+		case "status":
+			b, err := json.Marshal(currentStatus())
+			if err != nil {
+				reply = fmt.Sprintf("error: %v", err)
+			} else {
+				reply = string(b)
+			}
+		case "shutdown":
+			log.Printf("shutdown requested over the control channel")
+			requestShutdown()
+			reply = "ok"
+		case "signal":
+			if len(fields) != 2 {
+				reply = "error: usage: signal <name>"
+			} else if err := signalByName(fields[1]); err != nil {
+				reply = fmt.Sprintf("error: %v", err)
+			} else {
+				reply = "ok"
+			}
+		default:
+			reply = fmt.Sprintf("error: unknown command %q", fields[0])
+		}
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			log.Printf("failed to reply on control channel: %v", err)
+			return
+		}
+	}
+}
+
+// signalByName sends the named signal to the entrypoint.
+func signalByName(name string) error {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return fmt.Errorf("unknown signal %q", name)
+	}
+	return signalEntrypoint(sig)
+}
+
+// failureNotifyTimeout bounds how long notifying the host of a failure may
+// take, so that it can't hold up powering off.
+const failureNotifyTimeout = 2 * time.Second
+
+// failureEvent is what the host is sent when init fails.
+type failureEvent struct {
+	Event    string `json:"event"`
+	Category string `json:"category"`
+	ExitCode int    `json:"exit-code"`
+	Error    string `json:"error"`
+}
+
+// notifyFailure connects to the given vsock port on the host and sends it a
+// line of JSON describing the failure. This is best-effort: errors are logged,
+// and if it takes too long we give up on it.
+func notifyFailure(port uint32, ierr *InitError) {
+	b, err := json.Marshal(failureEvent{
+		Event:    "init-failed",
+		Category: ierr.Category.String(),
+		ExitCode: ierr.ExitCode(),
+		Error:    ierr.Err.Error(),
+	})
+	if err != nil {
+		log.Printf("failed to marshal failure event: %v", err)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			log.Printf("failed to notify host of failure: %v", err)
+			return
+		}
+		conn := os.NewFile(uintptr(fd), "vsock")
+		defer conn.Close()
+		if err := unix.Connect(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_HOST, Port: port}); err != nil {
+			log.Printf("failed to notify host of failure: %v", err)
+			return
+		}
+		if _, err := conn.Write(append(b, '\n')); err != nil {
+			log.Printf("failed to notify host of failure: %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(failureNotifyTimeout):
+		log.Printf("timed out notifying host of failure")
+	}
+}
@@ -0,0 +1,70 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	corePatternPath = "/proc/sys/kernel/core_pattern"
+	// defaultCorePattern names cores after the executable and pid.
+	defaultCorePattern = "core.%e.%p"
+	// maxCorePattern is the longest core_pattern the kernel accepts.
+	maxCorePattern = 127
+)
+
+// coreSpecifiers are the characters that may follow a % in a core pattern
+// (see core(5)).
+const coreSpecifiers = "%cdeEfghiIpPst"
+
+// setupCoreDumps checks the configured directory and file name pattern, and
+// has the kernel write cores there. The directory is created if needed, and
+// like /tmp is writable by everyone, since cores are written as the user of
+// the crashing process.
+func setupCoreDumps(cfg CoreDumps) error {
+	if !filepath.IsAbs(cfg.Dir) {
+		return fmt.Errorf("core dump directory %q must be an absolute path", cfg.Dir)
+	}
+	name := cfg.Pattern
+	if name == "" {
+		name = defaultCorePattern
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("core dump pattern %q must be a file name", name)
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(name) || !strings.ContainsRune(coreSpecifiers, rune(name[i])) {
+			return fmt.Errorf("core dump pattern %q has an unknown %% specifier", name)
+		}
+	}
+	pattern := filepath.Join(cfg.Dir, name)
+	if len(pattern) > maxCorePattern {
+		return fmt.Errorf("core dump pattern %q is longer than %d bytes", pattern, maxCorePattern)
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 01777); err != nil {
+		return err
+	}
+	// MkdirAll is subject to the umask.
+	if err := os.Chmod(cfg.Dir, 01777); err != nil {
+		return err
+	}
+	if err := os.WriteFile(corePatternPath, []byte(pattern+"\n"), 0644); err != nil {
+		return err
+	}
+	log.Printf("writing core dumps of the entrypoint to %s", pattern)
+	return nil
+}
@@ -0,0 +1,106 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// device is a character device node we expect under /dev.
+type device struct {
+	path         string
+	major, minor uint32
+	mode         uint32
+}
+
+// standardDevices are the nodes that programs commonly assume exist. The
+// numbers come from the kernel's Documentation/admin-guide/devices.txt, and
+// are the same on every architecture; only their encoding into a dev_t
+// differs, which unix.Mkdev takes care of.
+var standardDevices = []device{
+	{path: "/dev/null", major: 1, minor: 3, mode: 0666},
+	{path: "/dev/zero", major: 1, minor: 5, mode: 0666},
+	{path: "/dev/full", major: 1, minor: 7, mode: 0666},
+	{path: "/dev/random", major: 1, minor: 8, mode: 0666},
+	{path: "/dev/urandom", major: 1, minor: 9, mode: 0666},
+	{path: "/dev/tty", major: 5, minor: 0, mode: 0666},
+}
+
+// consoleDevice is the system console, which devtmpfs doesn't create on some
+// minimal kernels.
+var consoleDevice = device{path: "/dev/console", major: 5, minor: 1, mode: 0600}
+
+// ensureDevice creates the character device node if it does not exist.
+func ensureDevice(d device) error {
+	if _, err := os.Lstat(d.path); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	log.Printf("creating %s (%d:%d)", d.path, d.major, d.minor)
+	// mknod only honors the permission bits that the umask allows.
+	old := unix.Umask(0)
+	defer unix.Umask(old)
+	return unix.Mknod(d.path, unix.S_IFCHR|d.mode, int(unix.Mkdev(d.major, d.minor)))
+}
+
+// ensureDevices creates any of the standard device nodes that are missing,
+// e.g. because devtmpfs is not available.
+func ensureDevices() {
+	for _, d := range standardDevices {
+		if err := ensureDevice(d); err != nil {
+			log.Printf("failed to create %s: %v", d.path, err)
+		}
+	}
+}
+
+// standardLinks are the symlinks into /proc that programs commonly assume
+// exist under /dev (e.g. for process substitution), which devtmpfs doesn't
+// create, as pairs of the link and its target.
+var standardLinks = [][2]string{
+	{"/dev/fd", "/proc/self/fd"},
+	{"/dev/stdin", "/proc/self/fd/0"},
+	{"/dev/stdout", "/proc/self/fd/1"},
+	{"/dev/stderr", "/proc/self/fd/2"},
+}
+
+// ensureLinks creates any of the standard symlinks that are missing. Ones
+// that exist are left alone, whatever they are.
+func ensureLinks() {
+	for _, l := range standardLinks {
+		path, target := l[0], l[1]
+		if _, err := os.Lstat(path); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("failed to check %s: %v", path, err)
+			continue
+		}
+		if err := os.Symlink(target, path); err != nil {
+			log.Printf("failed to link %s to %s: %v", path, target, err)
+			continue
+		}
+		log.Printf("linked %s to %s", path, target)
+	}
+}
+
+// redirectToConsole points init's stdin, stdout and stderr (and so those of
+// the processes it starts) at /dev/console.
+func redirectToConsole() error {
+	f, err := os.OpenFile(consoleDevice.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, fd := range []int{0, 1, 2} {
+		if err := unix.Dup3(int(f.Fd()), fd, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
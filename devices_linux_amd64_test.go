@@ -0,0 +1,35 @@
+//go:build linux && amd64
+// +build linux,amd64
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestMkdevAmd64 pins the dev_t encoding that the device nodes are created
+// with on amd64, including numbers too large for the old 8-bit encoding.
+func TestMkdevAmd64(t *testing.T) {
+	for _, tc := range []struct {
+		major, minor uint32
+		dev          uint64
+	}{
+		{major: 1, minor: 3, dev: 0x103},
+		{major: 5, minor: 1, dev: 0x501},
+		{major: 259, minor: 0, dev: 0x10300},
+		{major: 8, minor: 65536, dev: 0x10000800},
+		{major: 4096, minor: 0, dev: 0x100000000000},
+	} {
+		if got := unix.Mkdev(tc.major, tc.minor); got != tc.dev {
+			t.Errorf("Mkdev(%d, %d) = %#x, want %#x", tc.major, tc.minor, got, tc.dev)
+		}
+		if major, minor := unix.Major(tc.dev), unix.Minor(tc.dev); major != tc.major || minor != tc.minor {
+			t.Errorf("%#x decodes to %d:%d, want %d:%d", tc.dev, major, minor, tc.major, tc.minor)
+		}
+	}
+}
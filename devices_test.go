@@ -0,0 +1,60 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestStandardDevicesMatchHost(t *testing.T) {
+	for _, d := range append(standardDevices, consoleDevice) {
+		var st unix.Stat_t
+		if err := unix.Stat(d.path, &st); err != nil {
+			t.Logf("skipping %s: %v", d.path, err)
+			continue
+		} else if st.Mode&unix.S_IFMT != unix.S_IFCHR {
+			t.Logf("skipping %s, which isn't a character device here", d.path)
+			continue
+		}
+		if major, minor := unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev)); major != d.major || minor != d.minor {
+			t.Errorf("%s is %d:%d, want %d:%d", d.path, major, minor, d.major, d.minor)
+		}
+	}
+}
+
+func TestEnsureDevice(t *testing.T) {
+	d := device{path: filepath.Join(t.TempDir(), "null"), major: 1, minor: 3, mode: 0666}
+	if err := ensureDevice(d); errors.Is(err, os.ErrPermission) {
+		t.Skipf("can't create device nodes: %v", err)
+	} else if err != nil {
+		t.Fatalf("ensureDevice() = %v", err)
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(d.path, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR {
+		t.Errorf("%s has mode %o, want a character device", d.path, st.Mode)
+	}
+	// The umask must not have taken away any of the permissions.
+	if perm := st.Mode &^ unix.S_IFMT; perm != d.mode {
+		t.Errorf("%s has permissions %o, want %o", d.path, perm, d.mode)
+	}
+	if major, minor := unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev)); major != d.major || minor != d.minor {
+		t.Errorf("%s is %d:%d, want %d:%d", d.path, major, minor, d.major, d.minor)
+	}
+
+	// An existing node is left alone.
+	if err := ensureDevice(d); err != nil {
+		t.Errorf("ensureDevice() of an existing node = %v", err)
+	}
+}
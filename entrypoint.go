@@ -0,0 +1,394 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultKillAfter is how long the entrypoint has to exit after SIGTERM,
+// before it is sent SIGKILL. This matches docker stop's default.
+const defaultKillAfter = 10 * time.Second
+
+// The policies for what to do when the entrypoint exits unsuccessfully. When
+// it exits successfully (with status 0), the VM is always powered off.
+const (
+	// Power off the VM (the default).
+	onFailurePoweroff = "poweroff"
+	// Keep the VM running, for inspection, until init is asked to stop.
+	onFailureKeepAlive = "keepalive"
+	// Start a root shell on the console, and power off once it exits.
+	onFailureShell = "shell"
+	// Start the entrypoint again.
+	onFailureRestart = "restart"
+)
+
+// superviseRestartDelay is how long to wait before restarting the entrypoint
+// in supervised mode, to avoid spinning on an entrypoint that exits at once.
+const superviseRestartDelay = time.Second
+
+// exitDescription describes the result of waiting for the entrypoint.
+func exitDescription(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// entrypoint holds everything needed to launch the entrypoint, and launch it
+// again if it is restarted.
+type entrypoint struct {
+	args   []string
+	argv0  string
+	dir    string
+	env    []string
+	stdin  *os.File
+	cred   *syscall.Credential
+	setsid bool
+	noFile uint64
+	// Whether to let the entrypoint dump core, without a size limit.
+	core bool
+	cg   *cgroup
+	// When set, the only capabilities the entrypoint may have.
+	caps []uintptr
+	// When set, the security label to exec the entrypoint with.
+	label *securityLabel
+	// When set, the privileges from a privilege spec.
+	priv *privileges
+	// When set, the change to the signal mask the entrypoint starts with.
+	sigmask *signalMask
+	// Existing namespaces to start the entrypoint in.
+	namespaces []namespace
+	// When set, the user namespace to run the entrypoint in.
+	userns *userNamespace
+	// How long to wait after SIGTERM before sending SIGKILL, if at all.
+	killAfter time.Duration
+	// Whether to launch with syscall.ForkExec and wait with wait4, rather
+	// than through os/exec.
+	forkExec bool
+	// When set, a fifo the entrypoint writes its real exit status to, once
+	// it has exited successfully.
+	exitFifo string
+	// With forkExec, closed once the running entrypoint has been waited
+	// for.
+	exited chan struct{}
+	// Where the entrypoint's output goes: our own stdout and stderr, unless
+	// it is being prefixed or sent to syslog.
+	stdout, stderr io.Writer
+}
+
+// command returns a new command for running the entrypoint.
+func (ep *entrypoint) command(ctx context.Context) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, ep.args[0], ep.args[1:]...)
+	if ep.argv0 != "" {
+		cmd.Args[0] = ep.argv0
+	}
+
+	// Set the working directory.
+	cmd.Dir = ep.dir
+
+	// TODO(mattmoor): Does this even make sense for init?
+	cmd.Stdout = ep.stdout
+	cmd.Stderr = ep.stderr
+	cmd.Stdin = ep.stdin
+	cmd.Env = ep.env
+	// Output that isn't a file is copied through pipes, which anything the
+	// entrypoint leaves running (e.g. after daemonizing) holds open too. So
+	// that this doesn't keep us waiting once it has exited, only wait as long
+	// for them as we would for it to exit.
+	_, stdoutFile := ep.stdout.(*os.File)
+	_, stderrFile := ep.stderr.(*os.File)
+	if (!stdoutFile || !stderrFile) && ep.killAfter > 0 {
+		cmd.WaitDelay = ep.killAfter
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: ep.cred,
+		Setsid:     ep.setsid,
+	}
+	if ep.userns != nil {
+		ep.userns.apply(cmd.SysProcAttr)
+	}
+	// A non-root entrypoint loses its capabilities on exec, unless they
+	// are ambient.
+	if cred := cmd.SysProcAttr.Credential; ep.caps != nil && cred != nil && cred.Uid != 0 {
+		cmd.SysProcAttr.AmbientCaps = ep.caps
+	}
+	// If the new session has a terminal for stdin, make it the controlling
+	// terminal so that job control works.
+	if ep.setsid && isTerminal(ep.stdin) {
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+	}
+	if ep.cg != nil {
+		ep.cg.apply(cmd.SysProcAttr)
+	}
+
+	// When we are asked to terminate, pass that on to the entrypoint rather
+	// than killing it outright, so that it has a chance to write its final
+	// output. We then keep waiting for it to exit, and cmd.Wait also waits for
+	// any copying of its stdio to finish, so that nothing is cut off by the
+	// deferred shutdown.
+	cmd.Cancel = func() error {
+		log.Printf("forwarding termination to the entrypoint")
+		if ep.killAfter > 0 {
+			pid := cmd.Process.Pid
+			time.AfterFunc(ep.killAfter, func() {
+				if currentStatus().PID != pid {
+					return
+				}
+				log.Printf("entrypoint did not exit within %v, killing it", ep.killAfter)
+				if err := signalEntrypoint(syscall.SIGKILL); err != nil {
+					log.Printf("failed to kill entrypoint: %v", err)
+				}
+			})
+		}
+		return signalEntrypoint(syscall.SIGTERM)
+	}
+	return cmd
+}
+
+// Starting the entrypoint is retried for errors that are likely to be
+// transient, e.g. ETXTBSY when the binary was written just before boot.
+const (
+	startRetries       = 5
+	startRetryInterval = 100 * time.Millisecond
+)
+
+// isTransientStartError returns whether starting the entrypoint failed in a
+// way that is worth retrying.
+func isTransientStartError(err error) bool {
+	return errors.Is(err, syscall.ETXTBSY) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR)
+}
+
+// start launches the entrypoint, and records it as running.
+func (ep *entrypoint) start(ctx context.Context) (*exec.Cmd, error) {
+	var (
+		cmd *exec.Cmd
+		err error
+	)
+	for i := 0; ; i++ {
+		if cmd, err = ep.launch(ctx); err == nil {
+			break
+		}
+		if !isTransientStartError(err) || i >= startRetries {
+			return nil, err
+		}
+		log.Printf("failed to start entrypoint, retrying in %v (%d/%d): %v", startRetryInterval, i+1, startRetries, err)
+		time.Sleep(startRetryInterval)
+	}
+	if ep.forkExec {
+		// Nothing in os/exec is watching ctx for us, so pass on termination
+		// ourselves.
+		exited := make(chan struct{})
+		ep.exited = exited
+		go func() {
+			select {
+			case <-ctx.Done():
+				if err := cmd.Cancel(); err != nil {
+					log.Printf("failed to signal entrypoint: %v", err)
+				}
+			case <-exited:
+			}
+		}()
+	}
+	if ep.cg != nil {
+		if err := ep.cg.add(cmd.Process.Pid); err != nil {
+			log.Printf("failed to add entrypoint to cgroup: %v", err)
+		}
+	}
+	setRunning(cmd.Process.Pid, ep.setsid)
+	return cmd, nil
+}
+
+// launch starts a new command for the entrypoint. Each attempt needs a new
+// command, since one can only be started once.
+func (ep *entrypoint) launch(ctx context.Context) (*exec.Cmd, error) {
+	cmd := ep.command(ctx)
+	start := cmd.Start
+	if ep.forkExec {
+		start = func() error { return forkExec(cmd) }
+	}
+	var prepare []func() error
+	if len(ep.namespaces) != 0 {
+		prepare = append(prepare, func() error { return enterNamespaces(ep.namespaces) })
+	}
+	if ep.caps != nil {
+		prepare = append(prepare, func() error { return dropCapabilities(ep.caps) })
+	}
+	if ep.label != nil {
+		prepare = append(prepare, ep.label.setExec)
+	}
+	if ep.sigmask != nil {
+		prepare = append(prepare, ep.sigmask.apply)
+	}
+	if ep.priv != nil {
+		prepare = append(prepare, ep.priv.apply)
+	}
+	if len(prepare) != 0 {
+		launch := start
+		start = func() error { return startOnThread(prepare, launch) }
+	}
+	if ep.noFile != 0 {
+		launch := start
+		start = func() error { return startWithNofile(ep.noFile, launch) }
+	}
+	if ep.core {
+		launch := start
+		start = func() error { return startWithRlimit(syscall.RLIMIT_CORE, "core", unix.RLIM_INFINITY, launch) }
+	}
+	if err := startWaited(cmd, start); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// wait waits for the started entrypoint to exit. With an exit fifo, a
+// successful exit (e.g. after daemonizing) is followed by waiting for the
+// status written to it.
+func (ep *entrypoint) wait(ctx context.Context, cmd *exec.Cmd) error {
+	var err error
+	if ep.forkExec {
+		err = waitPid(cmd.Process.Pid)
+		close(ep.exited)
+	} else {
+		err = cmd.Wait()
+		if errors.Is(err, exec.ErrWaitDelay) {
+			// It exited successfully, which is what matters.
+			log.Printf("entrypoint exited, but what it left running still holds its output, which is no longer copied")
+			err = nil
+		}
+	}
+	// Nothing may signal it from here on, even while we wait on the exit
+	// fifo, since its PID can be reused.
+	setReaped()
+	doneWaiting(cmd.Process.Pid)
+	flushOutput(ep.stdout, ep.stderr)
+	if err == nil && ep.exitFifo != "" {
+		err = readExitStatus(ctx, ep.exitFifo)
+	}
+	setStopping(exitCode(err))
+	return err
+}
+
+// forkExec launches the command with syscall.ForkExec, bypassing os/exec and
+// the goroutines it uses to copy stdio and wait. Only cmd's configuration is
+// used, and cmd.Process is set to the started process, which must be waited
+// for with waitPid rather than cmd.Wait.
+func forkExec(cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	stdin, ok := cmd.Stdin.(*os.File)
+	if !ok {
+		return fmt.Errorf("stdin must be a file, got %T", cmd.Stdin)
+	}
+	pid, err := syscall.ForkExec(cmd.Path, cmd.Args, &syscall.ProcAttr{
+		Dir:   cmd.Dir,
+		Env:   cmd.Env,
+		Files: []uintptr{stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()},
+		Sys:   cmd.SysProcAttr,
+	})
+	if err != nil {
+		return &os.PathError{Op: "fork/exec", Path: cmd.Path, Err: err}
+	}
+	cmd.Process, err = os.FindProcess(pid)
+	return err
+}
+
+// waitPid waits for the child pid to exit, and describes an unsuccessful exit
+// the way os/exec does.
+func waitPid(pid int) error {
+	var ws unix.WaitStatus
+	for {
+		_, err := unix.Wait4(pid, &ws, 0, nil)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return err
+		}
+		break
+	}
+	if ws.Signaled() || ws.ExitStatus() != 0 {
+		return &exitError{status: ws}
+	}
+	return nil
+}
+
+// exitError is an unsuccessful exit of the entrypoint that wasn't waited for
+// through os/exec, and is described the same way as an *exec.ExitError.
+type exitError struct {
+	status unix.WaitStatus
+	// Otherwise, an exit status reported some other way.
+	code int
+}
+
+func (e *exitError) Error() string {
+	if e.status.Signaled() {
+		return fmt.Sprintf("signal: %v", e.status.Signal())
+	} else if e.status != 0 {
+		return fmt.Sprintf("exit status %d", e.status.ExitStatus())
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// exitCode returns the exit code for the result of waiting for the
+// entrypoint, which for a signal is 128 plus its number (like a shell), or -1
+// if it didn't exit.
+func exitCode(err error) int {
+	var ee *exec.ExitError
+	var xe *exitError
+	var ws unix.WaitStatus
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ee):
+		ws = unix.WaitStatus(ee.Sys().(syscall.WaitStatus))
+	case errors.As(err, &xe):
+		if xe.status == 0 {
+			return xe.code
+		}
+		ws = xe.status
+	default:
+		return -1
+	}
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// restartable reports whether the entrypoint's failure err is one to restart
+// it after, given the restart-exit-codes it may be limited to.
+func restartable(codes []int, err error) bool {
+	return len(codes) == 0 || slices.Contains(codes, exitCode(err))
+}
+
+// runShell runs an interactive root shell on the console, for debugging a
+// failed entrypoint.
+func runShell(env []string) {
+	cmd := exec.Command("/bin/sh")
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	// TODO(mattmoor): Does this even make sense for init?
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runWaited(cmd); err != nil {
+		log.Printf("shell exited: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandSession(t *testing.T) {
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	for _, setsid := range []bool{false, true} {
+		ep := &entrypoint{args: []string{"/bin/true"}, stdin: stdin, setsid: setsid}
+		cmd := ep.command(context.Background())
+		if got := cmd.SysProcAttr.Setsid; got != setsid {
+			t.Errorf("setsid=%v: Setsid = %v", setsid, got)
+		}
+		// Without a terminal for stdin, there is no controlling terminal to
+		// take.
+		if cmd.SysProcAttr.Setctty {
+			t.Errorf("setsid=%v: Setctty = true with a non-terminal stdin", setsid)
+		}
+	}
+}
+
+func TestSessionOrphansReaped(t *testing.T) {
+	becomeSubreaper(t)
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	// The entrypoint leads a session of its own, and leaves a child behind
+	// when it exits, which is then ours to reap.
+	ep := &entrypoint{
+		args:   []string{"/bin/sh", "-c", "sleep 0.1 & exit 0"},
+		stdin:  stdin,
+		setsid: true,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	if err := runWaited(ep.command(context.Background())); err != nil {
+		t.Fatalf("running entrypoint: %v", err)
+	}
+	waitReaped(t)
+}
+
+// runForkExec runs the shell script the way the entrypoint is with
+// fork-exec, returning the result of waiting for it.
+func runForkExec(t *testing.T, script string) error {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Stdin = os.Stdin
+	if err := startWaited(cmd, func() error { return forkExec(cmd) }); err != nil {
+		t.Fatalf("starting %q: %v", script, err)
+	}
+	defer doneWaiting(cmd.Process.Pid)
+	return waitPid(cmd.Process.Pid)
+}
+
+// runExec runs the shell script through os/exec, returning the result of
+// waiting for it.
+func runExec(t *testing.T, script string) error {
+	t.Helper()
+	return runWaited(exec.Command("/bin/sh", "-c", script))
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script string
+		code   int
+		desc   string
+	}{
+		{name: "success", script: "exit 0", code: 0, desc: "exit status 0"},
+		{name: "failure", script: "exit 3", code: 3, desc: "exit status 3"},
+		{name: "signal", script: "kill -KILL $$", code: 128 + 9, desc: "signal: killed"},
+	} {
+		for _, run := range []struct {
+			name string
+			run  func(*testing.T, string) error
+		}{{"os/exec", runExec}, {"fork-exec", runForkExec}} {
+			t.Run(tc.name+"/"+run.name, func(t *testing.T) {
+				err := run.run(t, tc.script)
+				if got := exitCode(err); got != tc.code {
+					t.Errorf("exitCode(%v) = %d, want %d", err, got, tc.code)
+				}
+				if got := exitDescription(err); got != tc.desc {
+					t.Errorf("exitDescription(%v) = %q, want %q", err, got, tc.desc)
+				}
+			})
+		}
+	}
+
+	// An exit status reported some other way (e.g. through the exit fifo).
+	if got := exitCode(&exitError{code: 7}); got != 7 {
+		t.Errorf("exitCode() of a reported status = %d, want 7", got)
+	}
+	if got := exitDescription(&exitError{code: 7}); got != "exit status 7" {
+		t.Errorf("exitDescription() of a reported status = %q", got)
+	}
+	// The entrypoint never ran, so it didn't exit.
+	if got := exitCode(errors.New("fork/exec: no such file")); got != -1 {
+		t.Errorf("exitCode() of a start failure = %d, want -1", got)
+	}
+}
+
+// With its output copied through pipes (e.g. to add a prefix), an entrypoint
+// that daemonizes leaves a child holding them, which mustn't keep us waiting
+// long after it exits.
+func TestWaitDaemonizedOutput(t *testing.T) {
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	var out bytes.Buffer
+	ep := &entrypoint{
+		args:      []string{"/bin/sh", "-c", "sleep 5 2>/dev/null & echo started"},
+		stdin:     stdin,
+		stdout:    &out,
+		stderr:    os.Stderr,
+		killAfter: 100 * time.Millisecond,
+	}
+	start := time.Now()
+	cmd, err := ep.launch(context.Background())
+	if err != nil {
+		t.Fatalf("launch() = %v", err)
+	}
+	if err := ep.wait(context.Background(), cmd); err != nil {
+		t.Errorf("wait() = %v, want its successful exit", err)
+	}
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("wait() took %v, waiting on the background child", took)
+	}
+	if !strings.Contains(out.String(), "started") {
+		t.Errorf("output = %q, want what it wrote before exiting", out.String())
+	}
+}
+
+func TestRestartable(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		script string
+		err    error
+		codes  []int
+		want   bool
+	}{
+		{name: "any code", script: "exit 3", want: true},
+		{name: "any signal", script: "kill -TERM $$", want: true},
+		{name: "listed code", script: "exit 3", codes: []int{1, 3}, want: true},
+		{name: "unlisted code", script: "exit 2", codes: []int{1, 3}, want: false},
+		{name: "listed signal", script: "kill -TERM $$", codes: []int{128 + 15}, want: true},
+		{name: "unlisted signal", script: "kill -KILL $$", codes: []int{128 + 15}, want: false},
+		{name: "signal's number alone", script: "kill -TERM $$", codes: []int{15}, want: false},
+		{name: "reported code", err: &exitError{code: 42}, codes: []int{42}, want: true},
+		{name: "didn't exit", err: errors.New("fork/exec: no such file"), codes: []int{1}, want: false},
+		{name: "didn't exit, any code", err: errors.New("fork/exec: no such file"), want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.err
+			if tc.script != "" {
+				err = runExec(t, tc.script)
+			}
+			if got := restartable(tc.codes, err); got != tc.want {
+				t.Errorf("restartable(%v, %v) = %v, want %v", tc.codes, err, got, tc.want)
+			}
+		})
+	}
+}
+
+// A fork-exec'd entrypoint is waited for by pid alongside the reaper, which
+// must leave it alone however busy it is.
+func TestForkExecWithReaper(t *testing.T) {
+	becomeSubreaper(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reapZombieProcesses(ctx, defaultReapBatch)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		// Don't leave the last orphans for other tests.
+		waitReaped(t)
+	}()
+
+	for i := 0; i < 20; i++ {
+		// Each leaves orphans behind for the reaper as it exits.
+		err := runForkExec(t, "sleep 0.01 & sleep 0.01 & exit 5")
+		if got := exitCode(err); got != 5 {
+			t.Fatalf("run %d: exitCode(%v) = %d, want 5", i, err, got)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// category classifies why init failed. Each category has a distinct code, so
+// that operators can tell why a VM failed to boot from the code alone.
+//
+// The code is only reported (on the console, in the status, and to the host
+// when asked to), never returned: init powers the VM off rather than exiting,
+// so it is not the exit status of init, or of the VM.
+type category int
+
+const (
+	// The configuration could not be read or is invalid.
+	categoryConfig category = iota + 2
+	// A filesystem could not be mounted.
+	categoryMount
+	// The network could not be configured.
+	categoryNetwork
+	// The entrypoint could not be run, or failed.
+	categoryExec
+	// The VM could not be powered off.
+	categoryShutdown
+)
+
+func (c category) String() string {
+	switch c {
+	case categoryConfig:
+		return "config"
+	case categoryMount:
+		return "mount"
+	case categoryNetwork:
+		return "network"
+	case categoryExec:
+		return "exec"
+	case categoryShutdown:
+		return "shutdown"
+	default:
+		return fmt.Sprintf("category(%d)", int(c))
+	}
+}
+
+// InitError is a failure of init that powers off the VM.
+type InitError struct {
+	Category category
+	Err      error
+}
+
+func (e *InitError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Category, e.Err)
+}
+
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode is the code reported for this failure.
+func (e *InitError) ExitCode() int {
+	return int(e.Category)
+}
+
+// failureHook, when set, is called with every failure of init before it
+// powers off, e.g. to notify the host.
+var failureHook func(*InitError)
+
+// fail reports a failure of init, and panics so that the deferred shutdown
+// powers off the VM.
+func fail(c category, format string, args ...any) {
+	log.Panic(reportFailure(c, format, args...))
+}
+
+// reportFailure records a failure of init in the status, and announces it with
+// a parseable line on the console, e.g.
+//
+//	WOLFINIT: init-failed category=network code=4
+//
+// Unlike fail, it returns, for failures while already shutting down.
+func reportFailure(c category, format string, args ...any) *InitError {
+	err := &InitError{Category: c, Err: fmt.Errorf(format, args...)}
+	setFailed(err)
+	if failureHook != nil {
+		failureHook(err)
+	}
+	fmt.Fprintf(os.Stdout, "WOLFINIT: init-failed category=%s code=%d\n", c, err.ExitCode())
+	return err
+}
@@ -0,0 +1,71 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// The codes are what operators match on, so they must never change.
+func TestCategoryCodes(t *testing.T) {
+	for _, tc := range []struct {
+		category category
+		name     string
+		code     int
+	}{
+		{category: categoryConfig, name: "config", code: 2},
+		{category: categoryMount, name: "mount", code: 3},
+		{category: categoryNetwork, name: "network", code: 4},
+		{category: categoryExec, name: "exec", code: 5},
+		{category: categoryShutdown, name: "shutdown", code: 6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.category.String(); got != tc.name {
+				t.Errorf("String() = %q, want %q", got, tc.name)
+			}
+			err := &InitError{Category: tc.category, Err: errors.New("boom")}
+			if got := err.ExitCode(); got != tc.code {
+				t.Errorf("ExitCode() = %d, want %d", got, tc.code)
+			}
+			if got, want := err.Error(), tc.name+": boom"; got != want {
+				t.Errorf("Error() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestInitErrorUnwrap(t *testing.T) {
+	cause := errors.New("boom")
+	if err := error(&InitError{Category: categoryMount, Err: cause}); !errors.Is(err, cause) {
+		t.Errorf("errors.Is(%v, cause) = false", err)
+	}
+}
+
+func TestFail(t *testing.T) {
+	var got *InitError
+	failureHook = func(err *InitError) { got = err }
+	defer func() { failureHook = nil }()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("fail() did not panic")
+			}
+		}()
+		fail(categoryNetwork, "no %s", "lease")
+	}()
+	if got == nil {
+		t.Fatal("failure hook was not called")
+	}
+	if got.Category != categoryNetwork || got.ExitCode() != 4 || got.Err.Error() != "no lease" {
+		t.Errorf("failure hook got %#v (%v)", got, got)
+	}
+	if s := currentStatus(); s.ExitCode != 4 {
+		t.Errorf("status exit code = %d, want 4", s.ExitCode)
+	}
+}
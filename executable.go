@@ -0,0 +1,75 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The policies for an entrypoint that exists, but isn't executable.
+const (
+	// Fail with a message saying how to fix it (the default).
+	nonExecutableFail = "fail"
+	// Mark it executable.
+	nonExecutableChmod = "chmod"
+)
+
+// findEntrypoint returns the file that name would run: name itself when it is
+// a path, and otherwise the first executable file with that name on the PATH
+// (like exec.LookPath). Only when there is none is the first non-executable
+// one returned, so that one doesn't get in the way of an executable one that
+// comes after it.
+func findEntrypoint(name string) (string, os.FileInfo, bool) {
+	if strings.Contains(name, "/") {
+		fi, err := os.Stat(name)
+		return name, fi, err == nil
+	}
+	var found string
+	var foundInfo os.FileInfo
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		path := filepath.Join(dir, name)
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if fi.Mode()&0111 != 0 {
+			return path, fi, true
+		}
+		if found == "" {
+			found, foundInfo = path, fi
+		}
+	}
+	return found, foundInfo, found != ""
+}
+
+// checkExecutable applies the policy when the entrypoint exists but doesn't
+// have the execute bit, which otherwise only fails to start with a bare
+// "permission denied". An entrypoint that can't be found is left for
+// starting it to report.
+func checkExecutable(name, policy string) error {
+	path, fi, ok := findEntrypoint(name)
+	if !ok || !fi.Mode().IsRegular() || fi.Mode()&0111 != 0 {
+		return nil
+	}
+	switch policy {
+	case nonExecutableChmod:
+		log.Printf("entrypoint %s is not executable, marking it executable", path)
+		if err := os.Chmod(path, fi.Mode().Perm()|0111); err != nil {
+			return fmt.Errorf("marking entrypoint %s executable: %w", path, err)
+		}
+		return nil
+	case "", nonExecutableFail:
+		return fmt.Errorf("entrypoint %s is not executable (mode %v): mark it executable in the image with chmod +x, or set non-executable-entrypoint to %q",
+			path, fi.Mode().Perm(), nonExecutableChmod)
+	default:
+		return fmt.Errorf("unknown non-executable-entrypoint policy %q", policy)
+	}
+}
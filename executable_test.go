@@ -0,0 +1,103 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckExecutable(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mode     os.FileMode
+		policy   string
+		wantMode os.FileMode
+		wantErr  string
+	}{
+		{name: "executable", mode: 0755, wantMode: 0755},
+		{name: "not executable", mode: 0644, wantMode: 0644, wantErr: "chmod +x"},
+		{name: "not executable with fail", mode: 0644, policy: nonExecutableFail, wantMode: 0644, wantErr: "chmod +x"},
+		{name: "not executable with chmod", mode: 0640, policy: nonExecutableChmod, wantMode: 0751},
+		{name: "unknown policy", mode: 0644, policy: "ignore", wantMode: 0644, wantErr: "unknown"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "entrypoint")
+			if err := os.WriteFile(path, []byte("#!/bin/sh\n"), tc.mode); err != nil {
+				t.Fatal(err)
+			}
+			// WriteFile is subject to the umask.
+			if err := os.Chmod(path, tc.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			// It is found the same by path and on the PATH.
+			t.Setenv("PATH", dir)
+			for _, name := range []string{path, "entrypoint"} {
+				err := checkExecutable(name, tc.policy)
+				if tc.wantErr == "" && err != nil {
+					t.Errorf("checkExecutable(%q) = %v", name, err)
+				} else if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+					t.Errorf("checkExecutable(%q) = %v, want an error containing %q", name, err, tc.wantErr)
+				}
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fi.Mode().Perm(); got != tc.wantMode {
+				t.Errorf("mode = %v, want %v", got, tc.wantMode)
+			}
+		})
+	}
+}
+
+func TestCheckExecutableMissing(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	// Starting it reports that it doesn't exist.
+	for _, name := range []string{"missing", filepath.Join(t.TempDir(), "missing")} {
+		if err := checkExecutable(name, nonExecutableFail); err != nil {
+			t.Errorf("checkExecutable(%q) = %v", name, err)
+		}
+	}
+}
+
+// A non-executable file earlier on the PATH doesn't shadow an executable one,
+// just as it doesn't when the entrypoint is started.
+func TestCheckExecutableShadowed(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	shadow := filepath.Join(first, "entrypoint")
+	for _, f := range []struct {
+		path string
+		mode os.FileMode
+	}{{shadow, 0644}, {filepath.Join(second, "entrypoint"), 0755}} {
+		if err := os.WriteFile(f.path, []byte("#!/bin/sh\n"), f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(f.path, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", first+string(filepath.ListSeparator)+second)
+
+	for _, policy := range []string{nonExecutableFail, nonExecutableChmod} {
+		if err := checkExecutable("entrypoint", policy); err != nil {
+			t.Errorf("checkExecutable(%q) = %v", policy, err)
+		}
+	}
+	// Nor is the shadowing file marked executable.
+	fi, err := os.Stat(shadow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0644 {
+		t.Errorf("mode of %s = %v, want 0644", shadow, got)
+	}
+}
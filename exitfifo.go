@@ -0,0 +1,65 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// createExitFifo creates the named pipe that a daemonizing entrypoint writes
+// its final exit status to, owned by the user it runs as.
+func createExitFifo(path string, uid, gid int) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := unix.Mkfifo(path, 0600); err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// readExitStatus waits for a line holding the entrypoint's exit status (e.g.
+// "0\n") to be written to the fifo at path, and returns it as the result of
+// waiting for the entrypoint. It gives up if ctx is cancelled first.
+func readExitStatus(ctx context.Context, path string) error {
+	// Opening for writing too means we neither block until a writer shows
+	// up, nor see EOF when one comes and goes without writing anything.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Closing the fifo interrupts the read below.
+	stop := context.AfterFunc(ctx, func() { f.Close() })
+	defer stop()
+
+	log.Printf("waiting for the exit status on %s", path)
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if ctx.Err() != nil {
+		return fmt.Errorf("asked to stop before the exit status was written: %w", ctx.Err())
+	} else if err != nil {
+		return fmt.Errorf("reading exit status: %w", err)
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return fmt.Errorf("malformed exit status %q: %w", line, err)
+	}
+	log.Printf("read exit status %d from %s", code, path)
+	if code != 0 {
+		return &exitError{code: code}
+	}
+	return nil
+}
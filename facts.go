@@ -0,0 +1,58 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/vishvananda/netlink"
+)
+
+// collectFacts returns the facts discovered during boot that environment
+// values may reference:
+//
+//	WOLFINIT_HOSTNAME  - the hostname of the VM
+//	WOLFINIT_INTERFACE - the name of the network interface we configured
+//	WOLFINIT_IP        - the first IPv4 address of that interface
+//
+// Facts that could not be determined are omitted.
+func collectFacts(link netlink.Link) map[string]string {
+	facts := make(map[string]string, 3)
+	if h, err := os.Hostname(); err != nil {
+		log.Printf("failed to get hostname: %v", err)
+	} else {
+		facts["WOLFINIT_HOSTNAME"] = h
+	}
+	if link != nil {
+		facts["WOLFINIT_INTERFACE"] = link.Attrs().Name
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			log.Printf("failed to list addresses of %s: %v", link.Attrs().Name, err)
+		} else if len(addrs) > 0 {
+			facts["WOLFINIT_IP"] = addrs[0].IP.String()
+		}
+	}
+	return facts
+}
+
+// factRef matches a reference to a fact, e.g. ${WOLFINIT_IP}.
+var factRef = regexp.MustCompile(`\$\{(WOLFINIT_[A-Z0-9_]+)\}`)
+
+// expandFacts replaces references to known facts in the environment values.
+// Anything else that looks like a variable is left untouched.
+func expandFacts(env map[string]string, facts map[string]string) {
+	for k, v := range env {
+		env[k] = factRef.ReplaceAllStringFunc(v, func(ref string) string {
+			if fact, ok := facts[factRef.FindStringSubmatch(ref)[1]]; ok {
+				return fact
+			}
+			return ref
+		})
+	}
+}
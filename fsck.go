@@ -0,0 +1,64 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// The bits of fsck's exit status (see fsck(8)) that still leave the
+// filesystem fit to mount.
+const (
+	fsckCorrected      = 1
+	fsckRebootRequired = 2
+)
+
+// checkFilesystem runs fsck.<fstype> on the block device, automatically
+// repairing what it safely can, e.g. after an unclean shutdown. It is skipped
+// when the device isn't a block device, or (with a prominent log line) when
+// the image has no fsck for the filesystem type. An error means the
+// filesystem should not be mounted.
+func checkFilesystem(device, fstype string) error {
+	fi, err := os.Stat(device)
+	if err != nil {
+		return err
+	} else if fi.Mode()&os.ModeDevice == 0 {
+		log.Printf("not checking %s, which is not a block device", device)
+		return nil
+	}
+	// This needs init's own PATH to be set, which it is before anything is
+	// mounted.
+	fsck, err := exec.LookPath("fsck." + fstype)
+	if err != nil {
+		// This was asked for, so don't let it go unnoticed that it never
+		// happens.
+		log.Printf("fsck was requested for %s, but it can't be checked, so it is mounted UNCHECKED: %v", device, err)
+		return nil
+	}
+
+	log.Printf("checking %s with %s", device, fsck)
+	var out bytes.Buffer
+	// fsck.<type> -a <device>
+	cmd := exec.Command(fsck, "-a", device)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = runWaited(cmd)
+	switch code := exitCode(err); {
+	case code == 0:
+		log.Printf("%s is clean", device)
+	case code > 0 && code&^(fsckCorrected|fsckRebootRequired) == 0:
+		// Nothing is mounted from it yet, so there is nothing to reboot for.
+		log.Printf("fixed errors on %s: %s", device, bytes.TrimSpace(out.Bytes()))
+	default:
+		return fmt.Errorf("%s: %w: %s", fsck, err, bytes.TrimSpace(out.Bytes()))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/google/shlex"
+)
+
+// runHook runs the given command line to completion as init's own user
+// (root), with the provided environment and working directory.
+func runHook(ctx context.Context, name, command string, env []string, dir string) error {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return fmt.Errorf("splitting %s command: %w", name, err)
+	} else if len(args) == 0 {
+		return fmt.Errorf("empty %s command", name)
+	}
+	log.Printf("running %s command: %v", name, args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runWaited(cmd); err != nil {
+		return fmt.Errorf("running %s command: %w", name, err)
+	}
+	return nil
+}
+
+// defaultInitScripts is the directory of init scripts run when it exists and
+// no other directory is configured.
+const defaultInitScripts = "/etc/wolfinit/init.d"
+
+// runInitScripts runs each executable file in dir in lexical order, as root
+// with the provided environment and working directory. A failing script is
+// logged, and unless fatal is set the rest are still run.
+func runInitScripts(ctx context.Context, dir string, env []string, workdir string, fatal bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if fatal {
+			return err
+		}
+		log.Printf("failed to read init scripts: %v", err)
+		return nil
+	}
+	for _, e := range entries {
+		// Follow symlinks, as sysvinit's rc directories are made of them.
+		path := filepath.Join(dir, e.Name())
+		fi, err := os.Stat(path)
+		if err != nil {
+			log.Printf("skipping init script %s: %v", path, err)
+			continue
+		} else if !fi.Mode().IsRegular() || fi.Mode().Perm()&0111 == 0 {
+			log.Printf("skipping init script %s, which is not an executable file", path)
+			continue
+		}
+		log.Printf("running init script %s", path)
+		cmd := exec.CommandContext(ctx, path)
+		cmd.Dir = workdir
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := runWaited(cmd); err != nil {
+			if fatal {
+				return fmt.Errorf("running init script %s: %w", path, err)
+			}
+			log.Printf("init script %s failed: %v", path, err)
+		}
+	}
+	return nil
+}
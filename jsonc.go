@@ -0,0 +1,58 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// stripComments removes // line comments and /* */ block comments from JSON
+// (aka JSONC), leaving the contents of string literals untouched. Comments are
+// replaced with whitespace, so that offsets in any subsequent parse errors
+// still line up with the original file.
+func stripComments(b []byte) ([]byte, error) {
+	out := bytes.Clone(b)
+	blank := func(i int) {
+		// Preserve newlines so line numbers stay the same.
+		if out[i] != '\n' {
+			out[i] = ' '
+		}
+	}
+	for i := 0; i < len(out); i++ {
+		switch {
+		case out[i] == '"':
+			// Skip over the string, honoring escaped quotes.
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				blank(i)
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			start := i
+			blank(i)
+			blank(i + 1)
+			for i += 2; ; i++ {
+				if i+1 >= len(out) {
+					return nil, fmt.Errorf("unterminated comment at offset %d", start)
+				}
+				if out[i] == '*' && out[i+1] == '/' {
+					blank(i)
+					blank(i + 1)
+					i++
+					break
+				}
+				blank(i)
+			}
+		}
+	}
+	return out, nil
+}
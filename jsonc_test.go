@@ -0,0 +1,72 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import "testing"
+
+func TestStripComments(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", in: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "line", in: "{\"a\": 1} // one\n", want: "{\"a\": 1}       \n"},
+		{name: "line at the end", in: `{"a": 1}//`, want: `{"a": 1}  `},
+		{name: "block", in: `{/* x */"a": 1}`, want: `{       "a": 1}`},
+		{name: "block keeps newlines", in: "{/*\nx\n*/\"a\": 1}", want: "{  \n \n  \"a\": 1}"},
+		{name: "slashes in a string", in: `{"a": "http://x/*y*/"}`, want: `{"a": "http://x/*y*/"}`},
+		{name: "escaped quote in a string", in: `{"a": "\"//"} // c`, want: `{"a": "\"//"}     `},
+		{name: "block in a line comment", in: "1 // /* x\n", want: "1        \n"},
+		{name: "line in a block comment", in: "1 /* // */ 2", want: "1          2"},
+		{name: "unterminated block", in: `{"a": 1} /* x`, wantErr: true},
+		{name: "unterminated block at the end", in: `1 /*`, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := stripComments([]byte(tc.in))
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("stripComments() = %q, want an error", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("stripComments() = %v", err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("stripComments() = %q, want %q", got, tc.want)
+			}
+			if len(got) != len(tc.in) {
+				t.Errorf("stripComments() changed the length from %d to %d", len(tc.in), len(got))
+			}
+		})
+	}
+}
+
+func TestParseConfigComments(t *testing.T) {
+	const config = `{
+		// Where the entrypoint runs.
+		"work-dir": "/app", /* trailing */
+		"environment": {"URL": "http://example.com/*"}
+	}`
+	if _, err := parseConfig([]byte(config), false, false); err == nil {
+		t.Error("parseConfig() accepted comments when they aren't allowed")
+	}
+	ic, err := parseConfig([]byte(config), true, false)
+	if err != nil {
+		t.Fatalf("parseConfig() = %v", err)
+	}
+	if ic.WorkDir != "/app" {
+		t.Errorf("WorkDir = %q, want /app", ic.WorkDir)
+	}
+	if got := ic.Environment["URL"]; got != "http://example.com/*" {
+		t.Errorf("URL = %q, want it untouched", got)
+	}
+	if _, err := parseConfig([]byte(`{"work-dir": "/app"} /*`), true, false); err == nil {
+		t.Error("parseConfig() accepted an unterminated comment")
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// startWithNofile calls start (e.g. cmd.Start) with init's soft RLIMIT_NOFILE
+// temporarily set to nofile, so that only the process it forks inherits the
+// new limit. The limit is clamped to the hard limit.
+func startWithNofile(nofile uint64, start func() error) error {
+	return startWithRlimit(unix.RLIMIT_NOFILE, "nofile", nofile, start)
+}
+
+// startWithRlimit calls start with init's soft limit for the resource
+// temporarily set to cur, clamped to the hard limit, as for startWithNofile.
+//
+// The limit is process-wide while it is set, so anything else init starts in
+// the meantime would inherit it too. start must therefore run under reapMu
+// (i.e. through startWaited), which every start of a process holds.
+func startWithRlimit(resource int, name string, cur uint64, start func() error) error {
+	var orig unix.Rlimit
+	if err := unix.Getrlimit(resource, &orig); err != nil {
+		return err
+	}
+	lim := orig
+	lim.Cur = cur
+	if lim.Cur > lim.Max {
+		log.Printf("clamping requested %s limit %d to the hard limit %d", name, cur, lim.Max)
+		lim.Cur = lim.Max
+	}
+	log.Printf("setting the entrypoint %s limit to %d", name, lim.Cur)
+	if err := setrlimit(resource, &lim); err != nil {
+		return err
+	}
+	defer func() {
+		if err := setrlimit(resource, &orig); err != nil {
+			log.Printf("failed to restore %s limit: %v", name, err)
+		}
+	}()
+	return start()
+}
+
+// setrlimit sets one of init's own limits with a raw prlimit(2).
+//
+// The Go runtime raises its soft nofile limit at startup, and restores the
+// original one in the children it forks, unless the limit has since changed.
+// syscall.Setrlimit (which unix.Setrlimit calls) turns that off for good, so
+// that every later child (hooks, services, the shell) would inherit init's
+// raised limit, rather than just the entrypoint getting the one asked for.
+// The raw syscall leaves the runtime's bookkeeping alone, and since the limit
+// differs while the entrypoint is forked, it keeps the new one. (The one
+// exception is a requested limit of exactly one below the hard limit, which
+// is what the runtime itself set, so it gets the original limit instead.)
+func setrlimit(resource int, lim *unix.Rlimit) error {
+	if _, _, errno := unix.RawSyscall6(unix.SYS_PRLIMIT64, 0, uintptr(resource), uintptr(unsafe.Pointer(lim)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
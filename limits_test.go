@@ -0,0 +1,69 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// childNofile returns the soft nofile limit a child started with start sees.
+func childNofile(t *testing.T, start func(cmd *exec.Cmd) error) uint64 {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", "ulimit -n")
+	var out strings.Builder
+	cmd.Stdout = &out
+	if err := start(cmd); err != nil {
+		t.Fatalf("starting child: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("waiting for child: %v", err)
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(out.String()), 10, 64)
+	if err != nil {
+		t.Fatalf("parsing ulimit -n output %q: %v", out.String(), err)
+	}
+	return n
+}
+
+func TestStartWithNofile(t *testing.T) {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &lim); err != nil {
+		t.Fatal(err)
+	}
+	plain := func(cmd *exec.Cmd) error { return cmd.Start() }
+	before := childNofile(t, plain)
+
+	want := uint64(512)
+	if want == before || want == lim.Max-1 {
+		want++
+	}
+	if want > lim.Max {
+		t.Skipf("hard nofile limit %d is too low", lim.Max)
+	}
+	got := childNofile(t, func(cmd *exec.Cmd) error {
+		return startWithNofile(want, cmd.Start)
+	})
+	if got != want {
+		t.Errorf("entrypoint nofile limit = %d, want %d", got, want)
+	}
+
+	// Neither init's own limit, nor what later children get, may change.
+	var after unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &after); err != nil {
+		t.Fatal(err)
+	} else if after != lim {
+		t.Errorf("init's nofile limit = %+v, want %+v", after, lim)
+	}
+	if got := childNofile(t, plain); got != before {
+		t.Errorf("later child nofile limit = %d, want %d", got, before)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// defaultLocale is available without any locale data in most libcs.
+const defaultLocale = "C.UTF-8"
+
+// generateLocale runs locale-gen for the locale, if it is installed.
+func generateLocale(ctx context.Context, locale string, env []string) {
+	path, err := exec.LookPath("locale-gen")
+	if err != nil {
+		log.Printf("not generating locale %s: %v", locale, err)
+		return
+	}
+	cmd := exec.CommandContext(ctx, path, locale)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runWaited(cmd); err != nil {
+		log.Printf("failed to generate locale %s: %v", locale, err)
+	}
+}
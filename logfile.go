@@ -0,0 +1,42 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// defaultLogFileMaxSize is the size past which an existing log file is
+// rotated when we open it.
+const defaultLogFileMaxSize = 10 << 20
+
+// teeLog sends init's log output to the file at path, as well as the
+// console. If the file is already larger than maxSize, it is first moved
+// aside to path.1 (replacing any previous one), so each boot only keeps the
+// current and previous generation past that size.
+func teeLog(path string, maxSize int64) error {
+	if maxSize <= 0 {
+		maxSize = defaultLogFileMaxSize
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Size() > maxSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	return nil
+}
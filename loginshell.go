@@ -0,0 +1,27 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os/exec"
+)
+
+// loginShellScript has the login shell replace itself with the entrypoint,
+// once it has sourced the profile scripts. The entrypoint's argv is passed to
+// the shell as positional parameters, so that it is run exactly as it was
+// split, rather than being parsed again as shell syntax.
+const loginShellScript = `exec "$0" "$@"`
+
+// loginShellArgs wraps args to run under the given login shell, i.e.
+// <shell> -l -c 'exec "$0" "$@"' <args...>
+func loginShellArgs(shell string, args []string) ([]string, error) {
+	path, err := exec.LookPath(shell)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{path, "-l", "-c", loginShellScript}, args...), nil
+}
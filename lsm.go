@@ -0,0 +1,97 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/moby/sys/mount"
+)
+
+const (
+	securityfsRoot = "/sys/kernel/security"
+	apparmorRoot   = securityfsRoot + "/apparmor"
+	selinuxRoot    = "/sys/fs/selinux"
+)
+
+// securityLabel is the AppArmor profile or SELinux context the entrypoint is
+// confined to.
+type securityLabel struct {
+	// The line written to /proc/thread-self/attr/exec.
+	exec string
+	// For logging, e.g. "AppArmor profile foo".
+	description string
+}
+
+// setExec arranges for the calling thread's next exec (and that of processes
+// it forks) to transition to the label. This must be done on a thread given
+// to startOnThread.
+func (l *securityLabel) setExec() error {
+	if err := os.WriteFile("/proc/thread-self/attr/exec", []byte(l.exec), 0); err != nil {
+		return fmt.Errorf("setting %s for exec: %w", l.description, err)
+	}
+	log.Printf("transitioning the entrypoint to %s", l.description)
+	return nil
+}
+
+// newSecurityLabel validates the configured AppArmor profile or SELinux
+// context, and returns the label to exec the entrypoint with. If the LSM isn't
+// enabled, this logs and returns nil, so that the same image can boot on
+// kernels without it.
+func newSecurityLabel(profile, context string) (*securityLabel, error) {
+	switch {
+	case profile != "" && context != "":
+		return nil, errors.New("only one of an AppArmor profile and an SELinux context may be set")
+	case profile != "":
+		// The loaded profiles are listed in securityfs, which the kernel
+		// doesn't mount for us.
+		if _, err := os.Stat(apparmorRoot); errors.Is(err, os.ErrNotExist) {
+			if err := mount.Mount("securityfs", securityfsRoot, "securityfs", "nodev,nosuid,noexec"); err != nil {
+				log.Printf("failed to mount securityfs: %v", err)
+			}
+		}
+		if _, err := os.Stat(apparmorRoot); err != nil {
+			log.Printf("not applying AppArmor profile %s, since AppArmor is not enabled: %v", profile, err)
+			return nil, nil
+		}
+		b, err := os.ReadFile(apparmorRoot + "/profiles")
+		if err != nil {
+			return nil, err
+		}
+		// Each line looks like: name (mode)
+		found := false
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for s.Scan() {
+			if name, _, _ := strings.Cut(s.Text(), " ("); name == profile {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("AppArmor profile %s is not loaded", profile)
+		}
+		return &securityLabel{exec: "exec " + profile, description: "AppArmor profile " + profile}, nil
+	case context != "":
+		if _, err := os.Stat(selinuxRoot + "/enforce"); err != nil {
+			log.Printf("not applying SELinux context %s, since SELinux is not enabled: %v", context, err)
+			return nil, nil
+		}
+		// The kernel rejects contexts that aren't valid in the loaded policy.
+		if err := os.WriteFile(selinuxRoot+"/context", []byte(context), 0); err != nil {
+			return nil, fmt.Errorf("invalid SELinux context %s: %w", context, err)
+		}
+		return &securityLabel{exec: context, description: "SELinux context " + context}, nil
+	default:
+		return nil, nil
+	}
+}
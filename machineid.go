@@ -0,0 +1,78 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+
+	"github.com/moby/sys/mount"
+)
+
+const (
+	// machineIDGenerate asks for a random machine ID, unless the image
+	// already has one.
+	machineIDGenerate = "generate"
+	machineIDPath     = "/etc/machine-id"
+	dbusMachineIDPath = "/var/lib/dbus/machine-id"
+	// Where the machine ID is kept when /etc is read-only, to bind mount
+	// over it. /dev is a tmpfs that we always mount writable.
+	machineIDFallback = "/dev/.wolfinit-machine-id"
+)
+
+// machineIDPattern is the format of a machine ID: 128 bits, as lower case hex.
+var machineIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// setupMachineID writes /etc/machine-id (and D-Bus's copy), either with the
+// configured ID, or with a random one if the image doesn't have one.
+func setupMachineID(setting string) error {
+	id := setting
+	if setting == machineIDGenerate {
+		if b, err := os.ReadFile(machineIDPath); err == nil && machineIDPattern.Match(bytes.TrimSpace(b)) {
+			log.Printf("keeping the existing machine ID")
+			return nil
+		}
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		id = hex.EncodeToString(b[:])
+	} else if !machineIDPattern.MatchString(id) {
+		return fmt.Errorf("machine ID %q is not 32 lower case hex digits", id)
+	}
+	line := []byte(id + "\n")
+
+	if err := os.WriteFile(machineIDPath, line, 0444); errors.Is(err, syscall.EROFS) {
+		// Keep it in memory instead, and mount it over the image's (which
+		// must exist, to be mounted over).
+		if err := os.WriteFile(machineIDFallback, line, 0444); err != nil {
+			return err
+		}
+		if err := mount.Mount(machineIDFallback, machineIDPath, "", "bind,ro"); err != nil {
+			return fmt.Errorf("mounting over read-only %s: %w", machineIDPath, err)
+		}
+	} else if err != nil {
+		return err
+	}
+	log.Printf("set the machine ID to %s", id)
+
+	// D-Bus keeps its own copy, when it is installed.
+	if _, err := os.Stat(filepath.Dir(dbusMachineIDPath)); err == nil {
+		if err := os.WriteFile(dbusMachineIDPath, line, 0444); err != nil {
+			log.Printf("failed to write %s: %v", dbusMachineIDPath, err)
+		}
+	}
+	return nil
+}
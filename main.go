@@ -8,47 +8,120 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/exec"
 	"os/signal"
-	"strconv"
+	"path/filepath"
 	"syscall"
 	"time"
 
-	"github.com/google/shlex"
-	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/moby/sys/mount"
-	"github.com/u-root/u-root/pkg/dhclient"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 // This is to mimic the following "trap"
 // echo s > /proc/sysrq-trigger && echo o > /proc/sysrq-trigger && sleep infinity
+//
+// It never panics, since it runs last (deferred by main, or from the boot
+// watchdog) where nothing would recover, and PID 1 panicking panics the
+// kernel. Failures are only reported, and we fall back to reboot(2).
 func shutdown() {
 	// Write 's' to /proc/sysrq-trigger
-	if err := os.WriteFile("/proc/sysrq-trigger", []byte("s\n"), 0644); err != nil {
-		log.Fatalf("failed to sync %v", err)
+	if err := os.WriteFile(sysrqTrigger, []byte("s\n"), 0644); err != nil {
+		log.Print(reportFailure(categoryShutdown, "failed to sync: %v", err))
 	}
 
 	// Write 'o' to /proc/sysrq-trigger
-	if err := os.WriteFile("/proc/sysrq-trigger", []byte("o\n"), 0644); err != nil {
-		log.Fatalf("failed to poweroff %v", err)
+	if err := os.WriteFile(sysrqTrigger, []byte("o\n"), 0644); err != nil {
+		log.Print(reportFailure(categoryShutdown, "failed to poweroff: %v", err))
+		// There is no poweroff to wait for.
+		powerOff()
+	} else if poweroffBackstop > 0 {
+		// Normally we are powered off right away, but if the kernel is slow
+		// to act on the sysrq, power off directly.
+		time.Sleep(poweroffBackstop)
+		log.Printf("still running %v after requesting poweroff, powering off directly", poweroffBackstop)
+		powerOff()
 	}
 
 	// Block forever
 	select {}
 }
 
+// sysrqTrigger is where shutdown asks the kernel to sync and power off.
+var sysrqTrigger = "/proc/sysrq-trigger"
+
+// reboot is reboot(2), as a variable for tests.
+var reboot = unix.Reboot
+
+// powerOff powers off the VM with reboot(2), for when the sysrq didn't.
+func powerOff() {
+	if err := reboot(unix.LINUX_REBOOT_CMD_POWER_OFF); err != nil {
+		log.Printf("failed to power off: %v", err)
+	}
+}
+
+// defaultPoweroffBackstop is how long shutdown waits for the sysrq poweroff
+// to take effect before calling reboot(2) itself.
+const defaultPoweroffBackstop = 5 * time.Second
+
+// poweroffBackstop is the configured backstop, where 0 disables it.
+var poweroffBackstop = defaultPoweroffBackstop
+
+// delayShutdown waits for d before we power off, unless we're sent SIGTERM or
+// SIGINT to power off at once.
+func delayShutdown(d time.Duration) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	log.Printf("waiting %v before powering off", d)
+	select {
+	case sig := <-sigs:
+		log.Printf("received %v, powering off now", sig)
+	case <-time.After(d):
+	}
+}
+
 const defaultPath = "/sbin:/usr/sbin:/bin:/usr/bin:/usr/local/sbin:/usr/local/bin"
 
+const (
+	defaultConfigRetries       = 5
+	defaultConfigRetryInterval = 200 * time.Millisecond
+)
+
+// readConfig reads the file at path, retrying up to retries times at the
+// given interval if it does not exist yet.
+func readConfig(path string, retries int, interval time.Duration) ([]byte, error) {
+	for i := 0; ; i++ {
+		b, err := os.ReadFile(path)
+		if !errors.Is(err, os.ErrNotExist) || i >= retries {
+			return b, err
+		}
+		log.Printf("%s does not exist yet, retrying in %v (%d/%d)", path, interval, i+1, retries)
+		time.Sleep(interval)
+	}
+}
+
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	// When not booting as PID 1 (which the kernel may pass arguments), our
+	// flags check a configuration rather than booting.
+	if os.Getpid() != 1 && len(os.Args) > 1 {
+		os.Exit(checkConfig(os.Args[1:]))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	// Outside of a VM, when asked to, move to a mount namespace of our own
+	// before mounting anything.
+	if os.Getpid() != 1 {
+		enterMountNamespace(ctx)
+	}
+
 	// mount -t proc proc -o nodev,nosuid,hidepid=2 /proc
 	if err := mount.Mount("proc", "/proc", "proc", "nodev,nosuid,hidepid=2"); err != nil {
 		log.Printf("failed to mount: %v", err)
@@ -57,218 +130,572 @@ func main() {
 	// to `/proc/sysrq-trigger` to power off the system.
 	defer shutdown()
 
+	// Some settings are needed before /etc/apko.json is read, so they come
+	// from the kernel command line.
+	params := readCmdline()
+	poweroffBackstop = cmdlineDuration(params, "poweroff_timeout", defaultPoweroffBackstop)
+	stopWatchdog := startBootWatchdog(cmdlineDuration(params, "boot_timeout", defaultBootTimeout))
+
 	// mount -t devtmpfs -o nosuid,noexec devtmpfs /dev
 	if err := mount.Mount("devtmpfs", "/dev", "devtmpfs", "nosuid,noexec"); err != nil {
 		log.Printf("failed to mount: %v", err)
 	}
 	// mount -t sysfs -o nodev,nosuid,noexec sys /sys
-	if err := os.Mkdir("/sys", 0555); err != nil {
+	if err := os.Mkdir("/sys", 0555); err != nil && !errors.Is(err, os.ErrExist) {
 		log.Printf("failed to create /sys: %v", err)
 	} else if err := mount.Mount("sys", "/sys", "sysfs", "nodev,nosuid,noexec"); err != nil {
 		log.Printf("failed to mount: %v", err)
 	}
-	// mount -t cgroup -o all cgroup /sys/fs/cgroup
-	if err := mount.Mount("cgroup", "/sys/fs/cgroup", "cgroup", "all"); err != nil {
-		log.Printf("failed to mount: %v", err)
+	mountCgroups()
+
+	// The config may be written by an earlier boot stage, so retry briefly
+	// if it isn't there yet. Since the config itself is what we're reading,
+	// this is tuned via the kernel command line.
+	b, err := readConfig("/etc/apko.json",
+		cmdlineInt(params, "config_retries", defaultConfigRetries),
+		cmdlineDuration(params, "config_retry_interval", defaultConfigRetryInterval))
+	if err != nil {
+		log.Printf("failed to read /etc/apko.json: %v", err)
 	}
-	// mount -t tmpfs -o nodev,nosuid,noexec tmpfs /tmp
-	if err := mount.Mount("tmpfs", "/tmp", "tmpfs", "nodev,nosuid,noexec"); err != nil {
-		log.Printf("failed to mount: %v", err)
+	// Comments are only allowed when asked for, so that strict JSON remains
+	// the default, and likewise unknown settings are only rejected when asked
+	// to, so that older images keep booting.
+	ic, err := parseConfig(b, cmdlineBool(params, "config_comments", false), cmdlineBool(params, "config_strict", false))
+	if err != nil {
+		fail(categoryConfig, "failed to unmarshal /etc/apko.json: %v", err)
+	}
+
+	// This is stopped before we power off, so that the watchdog doesn't
+	// reset the VM while it shuts down.
+	if ic.Init.HardwareWatchdog != nil {
+		defer startHardwareWatchdog(*ic.Init.HardwareWatchdog)()
 	}
 
-	b, err := os.ReadFile("/etc/apko.json")
+	// As PID 1, we inherit orphaned processes and must reap them. Nothing we
+	// have run so far can have left any. The reaper is stopped (after a final
+	// pass) before we power off.
+	if !ic.Init.DisableReaper {
+		batch := defaultReapBatch
+		if ic.Init.ReapBatch != nil {
+			batch = *ic.Init.ReapBatch
+		}
+		reapCtx, stopReaper := context.WithCancel(context.Background())
+		reaperDone := make(chan struct{})
+		go func() {
+			defer close(reaperDone)
+			reapZombieProcesses(reapCtx, batch)
+		}()
+		defer func() {
+			stopReaper()
+			<-reaperDone
+		}()
+	}
+
+	// Resolve the user to run as, so that we can apply its environment.
+	applyCmdlineRunAs(params, ic)
+	uid, gid, user, err := resolveRunAs(ic.Accounts, ic.Init.MissingRunAs, ic.Init.DuplicateRunAs)
 	if err != nil {
-		log.Printf("failed to read /etc/apko.json: %v", err)
+		fail(categoryConfig, "failed to resolve run-as user: %v", err)
+	}
+	if err := resolveEnvironment(ic, user, params); err != nil {
+		fail(categoryConfig, "failed to resolve environment: %v", err)
+	}
+	if err := validateEnvironment(ic.Environment, ic.Init.InvalidEnvironment); err != nil {
+		fail(categoryConfig, "invalid environment: %v", err)
 	}
-	var ic ImageConfiguration
-	if err := json.Unmarshal(b, &ic); err != nil {
-		log.Panicf("failed to unmarshal /etc/apko.json: %v", err)
+	// The kernel doesn't give us a PATH, so set our own before running any of
+	// the programs we need while booting (e.g. mkfs for /tmp), which are
+	// resolved using it. The entrypoint's PATH is set once it is final, below.
+	bootPath := ic.Environment["PATH"]
+	if bootPath == "" {
+		bootPath = defaultPath
+	}
+	if err := os.Setenv("PATH", bootPath); err != nil {
+		fail(categoryExec, "failed to set PATH: %v", err)
 	}
 
-	// Ensure path is set in the environment.
-	if ic.Environment == nil {
-		ic.Environment = make(map[string]string, 1)
+	if ic.Init.ProcessTitle != "" {
+		setProcessTitle(ic.Init.ProcessTitle)
 	}
-	if _, ok := ic.Environment["PATH"]; !ok {
-		ic.Environment["PATH"] = defaultPath
+
+	if ic.Init.StatusFile != "" {
+		if err := os.MkdirAll(filepath.Dir(ic.Init.StatusFile), 0755); err != nil {
+			log.Printf("failed to create the directory of %s: %v", ic.Init.StatusFile, err)
+		}
+		setStatusFile(ic.Init.StatusFile)
 	}
 
-	// TODO(mattmoor): Set up other important devices.
+	if ic.Init.StatusSocket != "" {
+		stop, err := serveStatus(ic.Init.StatusSocket)
+		if err != nil {
+			log.Printf("failed to serve status on %s: %v", ic.Init.StatusSocket, err)
+		} else {
+			defer stop()
+		}
+	}
+
+	if port := ic.Init.FailureNotifyPort; port != 0 {
+		failureHook = func(err *InitError) { notifyFailure(port, err) }
+	}
+
+	if ic.Init.ControlPort != 0 {
+		// Shutting down is handled just like receiving SIGTERM: if the
+		// entrypoint is running it is asked to exit (which in turn powers off
+		// the VM), and otherwise the rest of init is aborted.
+		stop, err := serveControl(ic.Init.ControlPort, cancel)
+		if err != nil {
+			// Not every hypervisor exposes a vsock device.
+			log.Printf("failed to serve control channel on vsock port %d: %v", ic.Init.ControlPort, err)
+		} else {
+			defer stop()
+		}
+	}
+
+	// /tmp is mounted once we have read the config, since it may be backed
+	// by a disk rather than a tmpfs.
+	mountTmp(ic.Init.Tmp)
+
+	// mount --make-<propagation> /
+	if ic.Init.RootPropagation != "" {
+		if err := setPropagation("/", ic.Init.RootPropagation); err != nil {
+			log.Printf("failed to set propagation of /: %v", err)
+		}
+	}
+	if err := mountAll(ic.Init.Mounts, ic.Init.StrictMountOptions); err != nil {
+		fail(categoryMount, "%v", err)
+	}
+	if ic.Init.HugePages != nil {
+		setupHugePages(*ic.Init.HugePages)
+	}
+
+	// Now that any persistent mounts are available, start keeping a copy of
+	// our logs. Anything logged before this point only went to the console.
+	if ic.Init.LogFile != "" {
+		if err := teeLog(ic.Init.LogFile, ic.Init.LogFileMaxSize); err != nil {
+			log.Printf("failed to open log file %s: %v", ic.Init.LogFile, err)
+		}
+	}
+
+	// Make sure the devices programs commonly expect are there.
+	ensureDevices()
+	if ic.Init.DevLinks {
+		ensureLinks()
+	}
+	if ic.Init.Console {
+		if err := ensureDevice(consoleDevice); err != nil {
+			log.Printf("failed to create %s: %v", consoleDevice.path, err)
+		} else if ic.Init.ConsoleStdio {
+			if err := redirectToConsole(); err != nil {
+				log.Printf("failed to redirect stdio to %s: %v", consoleDevice.path, err)
+			}
+		}
+	}
+
+	setPhase(phaseNetworking)
 
 	// Set up network interfaces for loopback and veth.
 	if lo, err := netlink.LinkByName("lo"); err != nil {
-		log.Panicf("failed to get lo: %v", err)
+		fail(categoryNetwork, "failed to get lo: %v", err)
 	} else if err := netlink.LinkSetUp(lo); err != nil {
-		log.Panicf("failed to set lo up: %v", err)
+		fail(categoryNetwork, "failed to set lo up: %v", err)
+	}
+	linkUpRetries := defaultLinkUpRetries
+	if ic.Init.LinkUpRetries != nil {
+		linkUpRetries = *ic.Init.LinkUpRetries
 	}
-	// Find the 1st veth interface supporting broadcast and multi-cast
-	// that is up.
-	ll, err := netlink.LinkList()
+	links, err := findInterfaces(ic.Init.Interface, ic.Init.InterfaceMAC, ic.Init.AllInterfaces, time.Duration(ic.Init.InterfaceTimeout))
 	if err != nil {
-		log.Panicf("failed to list links: %v", err)
-	}
-	var eth0 netlink.Link
-	for _, link := range ll {
-		// This is to mirror this:
-		// ip -o link show | grep '<BROADCAST,MULTICAST>'
-		attr := link.Attrs()
-		if attr.Flags&net.FlagBroadcast != net.FlagBroadcast {
-			continue
-		} else if attr.Flags&net.FlagMulticast != net.FlagMulticast {
-			continue
-		}
-		eth0 = link
-		break
+		fail(categoryNetwork, "failed to find interfaces: %v", err)
+	} else if len(links) == 0 {
+		fail(categoryNetwork, "no suitable interface found to listen on")
 	}
-	if eth0 == nil {
-		log.Panicf("no suitable interface found to listen on")
-	} else if err := netlink.LinkSetUp(eth0); err != nil {
-		log.Panicf("failed to set network interface %s up: %v", eth0.Attrs().Name, err)
-	}
-
-	// Configure DHCP for eth0
-	// Modeled after the u-root configureAll function:
-	// https://github.com/u-root/u-root/blob/0c0df672/cmds/core/dhclient/dhclient.go#L67
-	{
-		c := dhclient.Config{
-			Timeout: 10 * time.Second,
-			Retries: 3,
-			V4ServerAddr: &net.UDPAddr{
-				IP:   net.IPv4bcast,
-				Port: dhcpv4.ServerPort,
-			},
-			LogLevel: dhclient.LogInfo, // There is nothing lower than info.
-		}
-		r := dhclient.SendRequests(ctx, []netlink.Link{eth0},
-			true /* ipv4 */, false /* ipv6 */, c, 10*time.Second)
-		for result := range r {
-			if result.Err != nil {
-				log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, result.Err)
-				continue
+	for _, link := range links {
+		if ic.Init.DisableIPv6 {
+			if err := disableIPv6(link); err != nil {
+				log.Printf("failed to disable IPv6 on %s: %v", link.Attrs().Name, err)
 			}
-			if err := result.Lease.Configure(); err != nil {
-				log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, err)
-				continue
-			}
-			// log.Printf("Configured %s with %s", result.Interface.Attrs().Name, result.Lease)
 		}
-		log.Printf("Finished trying to configure all interfaces.")
+		if err := setLinkUp(link, linkUpRetries); err != nil {
+			fail(categoryNetwork, "failed to set network interface %s up: %v", link.Attrs().Name, err)
+		}
 	}
 
+	addNeighbors(ic.Init.Neighbors, links[0])
+
+	leases, err := runDHCP(ctx, links, ic.Init.DHCPFailure, ic.Init.DHCPConfigureFailure, ic.Init.DHCPConcurrency, ic.Init.ResolvConfFallback)
+	if err != nil {
+		fail(categoryNetwork, "failed to configure networking: %v", err)
+	}
+	eth0 := links[0]
+	if len(leases) > 1 {
+		eth0 = setDefaultRoutes(leases, ic.Init.PrimaryInterface)
+	}
+
+	setPhase(phaseStarting)
+
+	// Now that the network is up, fill in any facts the environment refers to.
+	expandFacts(ic.Environment, collectFacts(eth0))
+
 	// The command passed to exec.Command[Context] is resolved using this
 	// process's PATH, not the PATH passed to the command execution, so set our
 	// own PATH here.
 	if err := os.Setenv("PATH", ic.Environment["PATH"]); err != nil {
-		log.Panicf("failed to set PATH: %v", err)
+		fail(categoryExec, "failed to set PATH: %v", err)
 	}
 
 	// Split entrypoint and cmd and build up the args.
-	args := []string{}
-	if ic.Entrypoint.Command != "" {
-		splitep, err := shlex.Split(ic.Entrypoint.Command)
-		if err != nil {
-			log.Panicf("failed to split entrypoint: %v", err)
+	args, err := entrypointArgs(ic)
+	if err != nil {
+		fail(categoryConfig, "failed to build entrypoint: %v", err)
+	}
+	if len(args) == 0 {
+		fail(categoryConfig, "no entrypoint or cmd configured")
+	}
+	if ic.Init.Interpreter != "" {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			fail(categoryExec, "failed to resolve interpreter %s: %v", args[0], err)
 		}
-		args = append(args, splitep...)
+	} else if err := checkExecutable(args[0], ic.Init.NonExecutableEntrypoint); err != nil {
+		fail(categoryExec, "%v", err)
 	}
-	if ic.Cmd != "" {
-		splitcmd, err := shlex.Split(ic.Cmd)
-		if err != nil {
-			log.Panicf("failed to split command: %v", err)
+	if ic.Init.LoginShell != "" {
+		if args, err = loginShellArgs(ic.Init.LoginShell, args); err != nil {
+			fail(categoryExec, "failed to resolve login shell %s: %v", ic.Init.LoginShell, err)
+		}
+		log.Printf("starting the entrypoint through a %s login shell", ic.Init.LoginShell)
+		if ic.Init.Argv0 != "" {
+			log.Printf("ignoring argv0 %q in a login shell", ic.Init.Argv0)
+			ic.Init.Argv0 = ""
+		}
+	}
+	if ic.Init.Strace != nil {
+		if traced, err := straceArgs(*ic.Init.Strace, args, uid, gid); err != nil {
+			log.Printf("not tracing the entrypoint: %v", err)
+		} else {
+			log.Printf("tracing the entrypoint with strace")
+			args = traced
+			if ic.Init.Argv0 != "" {
+				log.Printf("ignoring argv0 %q while tracing", ic.Init.Argv0)
+				ic.Init.Argv0 = ""
+			}
+		}
+	}
+	// The configured mounts are all in place by now, in case the working
+	// directory is on one of them.
+	dir, createdDir, err := resolveWorkDir(ic.WorkDir, ic.Init.MissingWorkDir)
+	if err != nil {
+		fail(categoryExec, "failed to resolve working directory: %v", err)
+	}
+	ep := &entrypoint{
+		args:      args,
+		argv0:     ic.Init.Argv0,
+		dir:       dir,
+		noFile:    ic.Init.NoFile,
+		setsid:    ic.Init.Setsid,
+		killAfter: defaultKillAfter,
+		forkExec:  ic.Init.ForkExec,
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+	}
+	if ic.Init.KillAfter != nil {
+		ep.killAfter = time.Duration(*ic.Init.KillAfter)
+	}
+	if ep.argv0 != "" {
+		// Make sure the binary resolves before we obscure its name.
+		if _, err := exec.LookPath(args[0]); err != nil {
+			fail(categoryExec, "failed to resolve entrypoint %s: %v", args[0], err)
 		}
-		args = append(args, splitcmd...)
 	}
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 
-	// Set the working directory.
-	cmd.Dir = ic.WorkDir
+	stdin, err := openStdin(ic.Init.Stdin)
+	if err != nil {
+		fail(categoryConfig, "failed to open stdin: %v", err)
+	}
+	ep.stdin = stdin
 
-	// TODO(mattmoor): Does this even make sense for init?
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
+	if ic.Init.Syslog != nil {
+		if ep.forkExec {
+			fail(categoryConfig, "syslog can't be used with fork-exec")
+		}
+		if ep.stdout, err = newSyslogWriter(*ic.Init.Syslog, os.Stdout); err != nil {
+			fail(categoryConfig, "invalid syslog settings: %v", err)
+		}
+		if ep.stderr, err = newSyslogWriter(*ic.Init.Syslog, os.Stderr); err != nil {
+			fail(categoryConfig, "invalid syslog settings: %v", err)
+		}
+	}
+	if ic.Init.OutputPrefix != "" || ic.Init.OutputTimestamp != "" {
+		if ep.forkExec {
+			fail(categoryConfig, "output-prefix and output-timestamp can't be used with fork-exec")
+		}
+		if isTerminal(ep.stdin) {
+			log.Printf("not prefixing the output of the entrypoint, which is interactive")
+		} else {
+			ep.stdout = newPrefixWriter(ep.stdout, ic.Init.OutputPrefix, ic.Init.OutputTimestamp)
+			ep.stderr = newPrefixWriter(ep.stderr, ic.Init.OutputPrefix, ic.Init.OutputTimestamp)
+		}
+	}
 
 	// Set up the environment.
-	cmd.Env = make([]string, 0, len(ic.Environment))
+	ep.env = make([]string, 0, len(ic.Environment))
 	for k, v := range ic.Environment {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
-	}
-
-	// Set the user to run as (default to 0).
-	var uid, gid int
-	if ic.Accounts.RunAs != "" {
-		// Search for a user whose name matches the runAs and if we find one
-		// then set uid to that user's UID.
-		runAs := ic.Accounts.RunAs
-		for _, acct := range ic.Accounts.Users {
-			if acct.UserName == runAs || fmt.Sprint(acct.UID) == runAs {
-				uid = int(acct.UID)
-				gid = int(acct.GID)
-				break
+		ep.env = append(ep.env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	// Group membership is declared on the groups, so look up which ones
+	// the run-as user belongs to.
+	username := ic.Accounts.RunAs
+	if user != nil {
+		username = user.UserName
+	}
+	ep.cred = &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: supplementaryGroups(ic.Accounts, username, gid),
+	}
+
+	if ic.Init.Privileges != nil {
+		if ic.Init.Capabilities != nil {
+			fail(categoryConfig, "capabilities may only be set in one of capabilities and privileges")
+		}
+		if ep.caps, ep.priv, err = resolvePrivileges(*ic.Init.Privileges, ep.cred); err != nil {
+			fail(categoryConfig, "invalid privileges: %v", err)
+		}
+		uid, gid = int(ep.cred.Uid), int(ep.cred.Gid)
+	}
+
+	// Now that we know who the entrypoint runs as, it can have the working
+	// directory if we created it.
+	if createdDir {
+		if err := chownWorkDir(dir, uid, gid); err != nil {
+			fail(categoryExec, "failed to change the owner of working directory %s: %v", dir, err)
+		}
+	}
+
+	if ic.Init.SignalMask != nil {
+		if ep.sigmask, err = parseSignalMask(*ic.Init.SignalMask); err != nil {
+			fail(categoryConfig, "invalid signal mask: %v", err)
+		}
+	}
+
+	if len(ic.Init.EnterNamespaces) != 0 {
+		if ep.namespaces, err = openNamespaces(ic.Init.EnterNamespaces); err != nil {
+			fail(categoryConfig, "invalid namespaces to enter: %v", err)
+		}
+	}
+
+	if ic.Init.NetworkNamespace != nil {
+		for _, ns := range ep.namespaces {
+			if ns.nstype == unix.CLONE_NEWNET {
+				fail(categoryConfig, "network-namespace can't be used with %s, which is also a network namespace", ns.path)
 			}
 		}
-		// If we didn't set uid, and the runAs isn't "root", then try to parse
-		// the runAs as a UID.
-		if uid == 0 && runAs != "root" {
-			uid, err = strconv.Atoi(ic.Accounts.RunAs)
-			if err != nil {
-				log.Panicf("failed to convert run-as user: %v", err)
+		ns, err := newNetworkNamespace(*ic.Init.NetworkNamespace, links)
+		if err != nil {
+			fail(categoryNetwork, "failed to set up the network namespace: %v", err)
+		}
+		ep.namespaces = append(ep.namespaces, ns)
+	}
+
+	if ic.Init.UserNamespace != nil {
+		if ep.userns, err = newUserNamespace(*ic.Init.UserNamespace, ep.cred); err != nil {
+			fail(categoryConfig, "invalid user namespace: %v", err)
+		}
+	}
+
+	if ic.Init.Capabilities != nil {
+		if ep.caps, err = parseCapabilities(ic.Init.Capabilities); err != nil {
+			fail(categoryConfig, "invalid capabilities: %v", err)
+		}
+	}
+
+	if ic.Init.ExitFifo != "" {
+		if err := createExitFifo(ic.Init.ExitFifo, uid, gid); err != nil {
+			fail(categoryExec, "failed to create exit fifo %s: %v", ic.Init.ExitFifo, err)
+		}
+		ep.exitFifo = ic.Init.ExitFifo
+	}
+
+	if ep.label, err = newSecurityLabel(ic.Init.AppArmorProfile, ic.Init.SELinuxContext); err != nil {
+		fail(categoryConfig, "invalid security label: %v", err)
+	}
+
+	// Place the entrypoint in a cgroup with the configured limits.
+	if ic.Init.Resources != (Resources{}) {
+		if ep.cg, err = newCgroup(ic.Init.Resources); err != nil {
+			log.Printf("failed to set up resource limits: %v", err)
+		} else if ic.Init.Resources.Memory != "" {
+			// Turn OOM kills, which are otherwise silent, into log lines.
+			if stop, err := ep.cg.watchOOM(); err != nil {
+				log.Printf("not watching for OOM kills: %v", err)
+			} else {
+				defer stop()
 			}
 		}
 	}
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Credential: &syscall.Credential{
-			Uid: uint32(uid),
-			Gid: uint32(gid),
-		},
+
+	if ic.Init.CoreDumps != nil {
+		if err := setupCoreDumps(*ic.Init.CoreDumps); err != nil {
+			fail(categoryConfig, "invalid core dump settings: %v", err)
+		}
+		ep.core = true
 	}
 
-	// Run the command, and wait for it to finish.
-	if err := cmd.Run(); err != nil {
-		log.Panicf("failed to run command: %v", err)
+	if ic.Init.MachineID != "" {
+		if err := setupMachineID(ic.Init.MachineID); err != nil {
+			log.Printf("failed to set up the machine ID: %v", err)
+		}
 	}
-}
+	applyChowns(ic.Init.Chown, uid, gid)
+	if err := validateChmods(ic.Init.Chmod); err != nil {
+		fail(categoryConfig, "invalid chmod: %v", err)
+	}
+	applyChmods(ic.Init.Chmod)
 
-type ImageEntrypoint struct {
-	// Required: The command of the entrypoint
-	Command string `json:"command,omitempty"`
-}
+	if len(ic.Init.CACertificates) != 0 {
+		if err := installCACerts(ctx, ic.Init.CACertificates); err != nil {
+			fail(categoryConfig, "failed to install CA certificates: %v", err)
+		}
+	}
 
-type ImageAccounts struct {
-	// Required: The user to run the container as. This can be a username or UID.
-	RunAs string `json:"run-as,omitempty" yaml:"run-as"`
-	// Required: List of users to populate the image with
-	Users []User `json:"users,omitempty" yaml:"users"`
-}
+	if ic.Init.LocaleGen {
+		generateLocale(ctx, ic.Environment["LANG"], ep.env)
+	}
 
-type User struct {
-	// Required: The name of the user
-	UserName string `json:"username,omitempty"`
-	// Required: The user ID
-	UID uint32 `json:"uid,omitempty"`
-	// Required: The user's group ID
-	GID uint32 `json:"gid,omitempty"`
-}
+	if err := waitForAll(ctx, ic.Init.WaitFor, ic.Init.WaitForTimeout, ep.env); err != nil {
+		fail(categoryExec, "failed waiting for dependencies: %v", err)
+	}
 
-type ImageConfiguration struct {
-	// Required: The entrypoint of the container image
-	//
-	// This typically is the path to the executable to run. Since many of
-	// images do not include a shell, this should be the full path
-	// to the executable.
-	Entrypoint ImageEntrypoint `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+	initScripts := ic.Init.InitScripts
+	if initScripts == "" {
+		if _, err := os.Stat(defaultInitScripts); err == nil {
+			initScripts = defaultInitScripts
+		}
+	}
+	if initScripts != "" {
+		if err := runInitScripts(ctx, initScripts, ep.env, ep.dir, ic.Init.InitScriptsFatal); err != nil {
+			fail(categoryExec, "failed init scripts: %v", err)
+		}
+	}
 
-	// Optional: The command of the container image
-	//
-	// These are the additional arguments to pass to the entrypoint.
-	Cmd string `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+	// Run any privileged setup before the entrypoint. Only the entrypoint is
+	// given the run-as credentials above, so this runs as root.
+	if ic.Init.PreStart != "" {
+		if err := runHook(ctx, "pre-start", ic.Init.PreStart, ep.env, ep.dir); err != nil {
+			fail(categoryExec, "failed pre-start: %v", err)
+		}
+	}
 
-	// Optional: The working directory of the container
-	WorkDir string `json:"work-dir,omitempty" yaml:"work-dir,omitempty"`
+	if ic.Init.PressureInterval > 0 {
+		defer startPressureSampler(time.Duration(ic.Init.PressureInterval), ic.Init.PressureMounts)()
+	}
 
-	// Optional: Account configuration for the container image
-	Accounts ImageAccounts `json:"accounts,omitempty" yaml:"accounts,omitempty"`
+	// Once the entrypoint is given up on (or the main service exits), the
+	// other services are stopped before we power off.
+	mainSvc, err := mainService(ic.Init.Services)
+	if err != nil {
+		fail(categoryConfig, "invalid services: %v", err)
+	}
+	if len(ic.Init.Services) != 0 {
+		stop, err := startServices(ic.Init.Services, ep.env, ep.dir, ep.cred, ep.killAfter, cancel)
+		if err != nil {
+			fail(categoryExec, "failed to start services: %v", err)
+		}
+		defer stop()
+	}
+	supervise := ic.Init.Supervise || mainSvc != ""
+	if ic.Init.Oneshot {
+		if supervise || ic.Init.OnFailure == onFailureRestart {
+			fail(categoryConfig, "oneshot cannot be combined with restarting the entrypoint")
+		}
+		// Keep a failed job around for inspection, unless told otherwise.
+		if ic.Init.OnFailure == "" {
+			ic.Init.OnFailure = onFailureKeepAlive
+		}
+	}
 
-	// Optional: Envionment variables to set in the container image
-	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// Run the command, and wait for it to finish.
+	cmd, err := ep.start(ctx)
+	if err != nil {
+		fail(categoryExec, "failed to start command: %v", err)
+	}
+	stopWatchdog()
+	announceBoot(ic.Init.BootMarker, ic.Init.BootMarkerFile, cmd.Process.Pid)
+	defer setPhase(phaseExited)
+	if ic.Init.ShutdownDelay > 0 {
+		defer delayShutdown(time.Duration(ic.Init.ShutdownDelay))
+	}
+	var backoff *restartBackoff
+	if ic.Init.RestartBackoff != nil {
+		backoff = newRestartBackoff(*ic.Init.RestartBackoff)
+	}
+	started := time.Now()
+	// restartDelay is how long to wait before restarting the entrypoint that
+	// was started at started.
+	restartDelay := func(def time.Duration) time.Duration {
+		if backoff == nil {
+			return def
+		}
+		return backoff.delay(time.Since(started))
+	}
+	for {
+		err := superviseWait(func() error { return ep.wait(ctx, cmd) })
+		// In supervised mode, the entrypoint's exit doesn't end the VM's life;
+		// only being asked to stop does.
+		if supervise && ctx.Err() == nil {
+			delay := restartDelay(superviseRestartDelay)
+			log.Printf("entrypoint exited (%v), restarting in %v", exitDescription(err), delay)
+			select {
+			case <-ctx.Done():
+				log.Printf("asked to stop, not restarting the entrypoint")
+				return
+			case <-time.After(delay):
+			}
+			if cmd, err = ep.start(ctx); err != nil {
+				fail(categoryExec, "failed to restart command: %v", err)
+			}
+			started = time.Now()
+			continue
+		}
+		if mainSvc != "" {
+			// We stopped the entrypoint ourselves, so how it exited doesn't
+			// matter.
+			log.Printf("entrypoint exited (%v) after main service %s", exitDescription(err), mainSvc)
+			return
+		}
+		if err == nil {
+			break
+		}
+		switch ic.Init.OnFailure {
+		case onFailureRestart:
+			if ctx.Err() != nil {
+				// We were asked to stop, so don't bring it back.
+				fail(categoryExec, "failed to run command: %v", err)
+			}
+			if codes := ic.Init.RestartExitCodes; !restartable(codes, err) {
+				fail(categoryExec, "failed to run command, not restarting since its exit code %d isn't one of %v: %v", exitCode(err), codes, err)
+			}
+			delay := restartDelay(0)
+			log.Printf("entrypoint failed, restarting in %v: %v", delay, err)
+			select {
+			case <-ctx.Done():
+				fail(categoryExec, "failed to run command: %v", err)
+			case <-time.After(delay):
+			}
+			if cmd, err = ep.start(ctx); err != nil {
+				fail(categoryExec, "failed to restart command: %v", err)
+			}
+			started = time.Now()
+			continue
+		case onFailureKeepAlive:
+			log.Printf("entrypoint failed, keeping the VM alive until asked to stop: %v", err)
+			<-ctx.Done()
+		case onFailureShell:
+			log.Printf("entrypoint failed, starting a shell: %v", err)
+			runShell(ep.env)
+		default:
+			fail(categoryExec, "failed to run command: %v", err)
+		}
+		break
+	}
 }
@@ -0,0 +1,58 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Without a writable sysrq trigger, shutdown must still get to reboot(2),
+// rather than panicking (which in PID 1 panics the kernel).
+func TestShutdownWithoutSysrq(t *testing.T) {
+	var reported []category
+	failureHook = func(err *InitError) { reported = append(reported, err.Category) }
+	oldTrigger, oldReboot, oldBackstop := sysrqTrigger, reboot, poweroffBackstop
+	defer func() {
+		failureHook = nil
+		sysrqTrigger, reboot, poweroffBackstop = oldTrigger, oldReboot, oldBackstop
+	}()
+	sysrqTrigger = filepath.Join(t.TempDir(), "missing", "sysrq-trigger")
+	// Even with the backstop disabled, there is no poweroff to wait for.
+	poweroffBackstop = 0
+
+	rebooted := make(chan int, 1)
+	reboot = func(cmd int) error {
+		rebooted <- cmd
+		return nil
+	}
+	// shutdown never returns, so its goroutine is left blocked.
+	panicked := make(chan any, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked <- r
+			}
+		}()
+		shutdown()
+	}()
+
+	select {
+	case r := <-panicked:
+		t.Fatalf("shutdown() panicked: %v", r)
+	case <-rebooted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown() did not power off with reboot(2)")
+	}
+	if len(reported) != 2 || reported[0] != categoryShutdown || reported[1] != categoryShutdown {
+		t.Errorf("reported failures %v, want two in %v", reported, categoryShutdown)
+	}
+	if s := currentStatus(); s.ExitCode != int(categoryShutdown) {
+		t.Errorf("status exit code = %d, want %d", s.ExitCode, categoryShutdown)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build darwin || windows
+// +build darwin windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func main() {
+	fmt.Fprintf(os.Stderr, "wolfinit only runs on Linux, not %s\n", runtime.GOOS)
+	os.Exit(1)
+}
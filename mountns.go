@@ -0,0 +1,82 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/moby/sys/mount"
+)
+
+// mountNamespaceEnv marks the copy of init that was re-executed in a new
+// mount namespace, so that it doesn't do so again.
+const mountNamespaceEnv = "WOLFINIT_MOUNT_NAMESPACE"
+
+// enterMountNamespace makes sure init runs in a mount namespace of its own
+// when it is configured to, so that the mounts it makes aren't seen outside of
+// it. As PID 1 there is nobody else to see them, so this is only for when init
+// isn't PID 1.
+//
+// This must happen before anything at all is mounted, /proc and /dev
+// included, which is before the configuration is otherwise read, so it takes
+// a look at the configuration of its own. That it can't be read or parsed
+// is left for init to report as usual.
+//
+// A Go program can't unshare its mount namespace in place, since that only
+// moves the calling thread, so instead init is re-executed in a new one. Its
+// exit status becomes ours, and this only returns in the new namespace (or
+// when none is wanted).
+func enterMountNamespace(ctx context.Context) {
+	if os.Getenv(mountNamespaceEnv) != "" {
+		// A new namespace starts with copies of the mounts it was made
+		// from, which still propagate back when they were shared, so stop
+		// that before mounting anything.
+		if err := mount.MakeRPrivate("/"); err != nil {
+			fail(categoryMount, "failed to make / private in the new mount namespace: %v", err)
+		}
+		os.Unsetenv(mountNamespaceEnv)
+		log.Printf("running in a new mount namespace")
+		return
+	}
+	params := readCmdline()
+	b, err := readConfig("/etc/apko.json",
+		cmdlineInt(params, "config_retries", defaultConfigRetries),
+		cmdlineDuration(params, "config_retry_interval", defaultConfigRetryInterval))
+	if err != nil {
+		return
+	}
+	ic, err := parseConfig(b, cmdlineBool(params, "config_comments", false), false)
+	if err != nil || !ic.Init.MountNamespace {
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Args = os.Args
+	cmd.Env = append(os.Environ(), mountNamespaceEnv+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	// Pass on termination, so that it shuts down as it would have here.
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	log.Printf("re-executing in a new mount namespace")
+	err = cmd.Run()
+	if err != nil {
+		log.Printf("init in the new mount namespace exited: %v", err)
+	}
+	// Nothing was set up here that needs undoing (not even the shutdown,
+	// which belongs to the copy in the new namespace).
+	code := exitCode(err)
+	if code < 0 {
+		code = 1
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,107 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// genericMountOptions are the options every filesystem accepts, which mount
+// turns into flags rather than passing them on to the filesystem.
+var genericMountOptions = map[string]bool{
+	"defaults": true, "ro": true, "rw": true, "suid": true, "nosuid": true,
+	"dev": true, "nodev": true, "exec": true, "noexec": true, "sync": true,
+	"async": true, "dirsync": true, "remount": true, "mand": true,
+	"nomand": true, "atime": true, "noatime": true, "diratime": true,
+	"nodiratime": true, "bind": true, "rbind": true, "relatime": true,
+	"norelatime": true, "strictatime": true, "nostrictatime": true,
+	"lazytime": true, "nolazytime": true,
+	"shared": true, "rshared": true, "slave": true, "rslave": true,
+	"private": true, "rprivate": true, "unbindable": true, "runbindable": true,
+}
+
+// fsMountOptions are the keys of the options that common filesystem types
+// accept, and whether each takes a value. Types that aren't listed here
+// aren't checked.
+var fsMountOptions = map[string]map[string]bool{
+	"tmpfs": {
+		"size": true, "nr_blocks": true, "nr_inodes": true, "mode": true,
+		"uid": true, "gid": true, "huge": true, "mpol": true,
+		"inode32": false, "inode64": false, "noswap": false,
+	},
+	"ext4": {
+		"errors": true, "data": true, "commit": true, "barrier": true,
+		"nobarrier": false, "discard": false, "nodiscard": false,
+		"journal_checksum": false, "nojournal_checksum": false,
+		"noload": false, "user_xattr": false, "nouser_xattr": false,
+		"acl": false, "noacl": false, "stripe": true, "resuid": true,
+		"resgid": true, "sb": true, "init_itable": true, "noinit_itable": false,
+		"dax": false, "quota": false, "noquota": false, "usrquota": false,
+		"grpquota": false, "prjquota": false, "journal_ioprio": true,
+	},
+	"xfs": {
+		"allocsize": true, "attr2": false, "noattr2": false, "discard": false,
+		"nodiscard": false, "grpid": false, "nogrpid": false, "inode32": false,
+		"inode64": false, "largeio": false, "nolargeio": false, "logbufs": true,
+		"logbsize": true, "logdev": true, "rtdev": true, "noalign": false,
+		"norecovery": false, "nouuid": false, "quota": false, "noquota": false,
+		"uquota": false, "gquota": false, "pquota": false, "wsync": false,
+		"dax": false,
+	},
+	"9p": {
+		"trans": true, "version": true, "msize": true, "cache": true,
+		"access": true, "aname": true, "uname": true, "dfltuid": true,
+		"dfltgid": true, "posixacl": false, "noextend": false, "nodevmap": false,
+		"port": true, "privport": false, "loose": false, "fscache": false,
+		"mmap": false,
+	},
+	"virtiofs": {
+		"dax": false, "dax=always": false, "dax=never": false, "dax=inode": false,
+	},
+	"overlay": {
+		"lowerdir": true, "upperdir": true, "workdir": true, "redirect_dir": true,
+		"index": true, "metacopy": true, "xino": true, "volatile": false,
+		"userxattr": false, "nfs_export": true,
+	},
+}
+
+// checkMountOptions returns the problems with the mount's options: empty
+// options, unknown ones for its filesystem type, and missing or unexpected
+// values. The problems are meant to be warnings, since the kernel may accept
+// options we don't know about.
+func checkMountOptions(m Mount) []string {
+	if m.Options == "" {
+		return nil
+	}
+	known := fsMountOptions[m.Type]
+	var problems []string
+	for _, opt := range strings.Split(m.Options, ",") {
+		key, value, hasValue := strings.Cut(opt, "=")
+		switch {
+		case opt == "":
+			problems = append(problems, "empty option (from a stray comma)")
+		case genericMountOptions[opt]:
+		case known == nil:
+			// We don't know this filesystem type's options.
+		default:
+			if _, ok := known[opt]; ok && hasValue {
+				// e.g. dax=always, which is listed whole.
+				continue
+			}
+			takesValue, ok := known[key]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("unknown %s option %q", m.Type, key))
+			} else if takesValue && (!hasValue || value == "") {
+				problems = append(problems, fmt.Sprintf("%s option %q needs a value", m.Type, key))
+			} else if !takesValue && hasValue {
+				problems = append(problems, fmt.Sprintf("%s option %q doesn't take a value", m.Type, key))
+			}
+		}
+	}
+	return problems
+}
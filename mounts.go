@@ -0,0 +1,242 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/moby/sys/mount"
+)
+
+// propagations is the set of supported mount propagation modes, which are
+// passed through to mount as the equivalent of `mount --make-<mode>`.
+var propagations = map[string]bool{
+	"shared":      true,
+	"rshared":     true,
+	"slave":       true,
+	"rslave":      true,
+	"private":     true,
+	"rprivate":    true,
+	"unbindable":  true,
+	"runbindable": true,
+}
+
+// setPropagation changes the propagation of the mount at target, e.g.
+// mount --make-rshared /
+func setPropagation(target, propagation string) error {
+	if !propagations[propagation] {
+		return fmt.Errorf("unsupported mount propagation %q", propagation)
+	}
+	// An empty device makes this a propagation-only change of an existing
+	// mount, rather than a new mount.
+	return mount.Mount("", target, "", propagation)
+}
+
+// sortMounts returns the mounts in the order to mount them: by Order, and
+// then with parents before the mounts nested in them (e.g. /a before /a/b),
+// keeping the declaration order otherwise.
+func sortMounts(mounts []Mount) []Mount {
+	mounts = slices.Clone(mounts)
+	depth := func(m Mount) int {
+		return strings.Count(filepath.Clean(m.Target), "/")
+	}
+	slices.SortStableFunc(mounts, func(a, b Mount) int {
+		if a.Order != b.Order {
+			return a.Order - b.Order
+		}
+		return depth(a) - depth(b)
+	})
+	for i, m := range mounts {
+		for _, later := range mounts[i+1:] {
+			if strings.HasPrefix(filepath.Clean(m.Target), filepath.Clean(later.Target)+"/") {
+				log.Printf("%s is mounted before %s, which it is nested in, because of their order", m.Target, later.Target)
+			}
+		}
+	}
+	return mounts
+}
+
+// mountAll mounts the configured filesystems, in the order given by
+// sortMounts, creating their mount points as needed. The entrypoint expects
+// them, so the first that fails to mount is returned as an error. Problems
+// with a mount's options are logged, and when strict are an error too.
+func mountAll(mounts []Mount, strict bool) error {
+	for _, m := range sortMounts(mounts) {
+		if problems := checkMountOptions(m); len(problems) != 0 {
+			for _, p := range problems {
+				log.Printf("mount of %s: %s", m.Target, p)
+			}
+			if strict {
+				return fmt.Errorf("not mounting %s, since its options are invalid: %s", m.Target, strings.Join(problems, "; "))
+			}
+		}
+		if m.Fsck {
+			if err := checkFilesystem(m.Source, m.Type); err != nil {
+				return fmt.Errorf("not mounting %s, since checking it failed: %w", m.Target, err)
+			}
+		}
+		if err := os.MkdirAll(m.Target, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", m.Target, err)
+		}
+		// mount -t <type> -o <options> <source> <target>
+		if err := mount.Mount(m.Source, m.Target, m.Type, m.Options); err != nil {
+			return fmt.Errorf("failed to mount %s: %w", m.Target, err)
+		}
+		if m.Propagation != "" {
+			if err := setPropagation(m.Target, m.Propagation); err != nil {
+				return fmt.Errorf("failed to set propagation of %s: %w", m.Target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// mountTmp mounts /tmp, either as a tmpfs (the default) or from the
+// configured block device, falling back to a tmpfs if the latter fails.
+func mountTmp(cfg TmpConfig) {
+	if cfg.Device != "" {
+		if err := mountTmpDevice(cfg); err != nil {
+			log.Printf("failed to mount %s on /tmp, falling back to tmpfs: %v", cfg.Device, err)
+		} else {
+			return
+		}
+	}
+	// mount -t tmpfs -o nodev,nosuid,noexec tmpfs /tmp
+	if err := mount.Mount("tmpfs", "/tmp", "tmpfs", "nodev,nosuid,noexec"); err != nil {
+		log.Printf("failed to mount: %v", err)
+	}
+}
+
+// mountTmpDevice mounts the configured block device on /tmp, formatting it
+// first if it is blank and formatting is enabled.
+func mountTmpDevice(cfg TmpConfig) error {
+	fi, err := os.Stat(cfg.Device)
+	if err != nil {
+		return err
+	} else if fi.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("%s is not a block device", cfg.Device)
+	}
+	fstype := cfg.Type
+	if fstype == "" {
+		fstype = "ext4"
+	}
+	if cfg.Format {
+		blank, err := isBlank(cfg.Device)
+		if err != nil {
+			return err
+		}
+		if blank {
+			log.Printf("formatting %s as %s", cfg.Device, fstype)
+			var out bytes.Buffer
+			cmd := exec.Command("mkfs."+fstype, cfg.Device)
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			if err := runWaited(cmd); err != nil {
+				return fmt.Errorf("mkfs.%s: %w: %s", fstype, err, out.Bytes())
+			}
+		}
+	}
+	if cfg.Fsck {
+		if err := checkFilesystem(cfg.Device, fstype); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll("/tmp", 01777); err != nil {
+		return err
+	}
+	// mount -t <type> -o nodev,nosuid,noexec <device> /tmp
+	if err := mount.Mount(cfg.Device, "/tmp", fstype, "nodev,nosuid,noexec"); err != nil {
+		return err
+	}
+	// Unlike a tmpfs, the root of a fresh filesystem isn't world-writable.
+	return os.Chmod("/tmp", 01777)
+}
+
+// blankProbeSize is how much of the start of a device we check when deciding
+// whether it holds a filesystem, which covers the superblocks of the common
+// Linux filesystems.
+const blankProbeSize = 1 << 20
+
+// isBlank returns whether the start of the device is all zeroes, which is
+// how we recognize a fresh disk that has never been formatted.
+func isBlank(device string) (bool, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	buf := make([]byte, blankProbeSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return bytes.Count(buf[:n], []byte{0}) == n, nil
+}
+
+// defaultHugePagesPath is where hugetlbfs is conventionally mounted.
+const defaultHugePagesPath = "/dev/hugepages"
+
+// setupHugePages mounts hugetlbfs, and reserves the requested number of huge
+// pages, refusing to reserve more than the VM's memory.
+func setupHugePages(cfg HugePages) {
+	if cfg.Reserve < 0 {
+		log.Printf("not reserving a negative number (%d) of huge pages", cfg.Reserve)
+	} else if cfg.Reserve > 0 {
+		if err := reserveHugePages(uint64(cfg.Reserve)); err != nil {
+			log.Printf("failed to reserve huge pages: %v", err)
+		}
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = defaultHugePagesPath
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		log.Printf("failed to create %s: %v", path, err)
+		return
+	}
+	// mount -t hugetlbfs -o nodev,nosuid,noexec hugetlbfs /dev/hugepages
+	if err := mount.Mount("hugetlbfs", path, "hugetlbfs", "nodev,nosuid,noexec"); err != nil {
+		log.Printf("failed to mount hugetlbfs at %s: %v", path, err)
+	}
+}
+
+// reserveHugePages reserves n huge pages of the default size, and logs how
+// many the kernel actually managed to reserve.
+func reserveHugePages(n uint64) error {
+	mi, err := readMeminfo()
+	if err != nil {
+		return err
+	}
+	size := mi["Hugepagesize"]
+	if size == 0 {
+		return fmt.Errorf("the kernel doesn't support huge pages")
+	} else if n*size >= mi["MemTotal"] {
+		return fmt.Errorf("%d huge pages of %dKiB would take all %dKiB of memory", n, size, mi["MemTotal"])
+	}
+	const nrHugePages = "/proc/sys/vm/nr_hugepages"
+	if err := os.WriteFile(nrHugePages, []byte(strconv.FormatUint(n, 10)), 0644); err != nil {
+		return err
+	}
+	// The kernel reserves as many as it can, which may be fewer when memory
+	// is fragmented.
+	b, err := os.ReadFile(nrHugePages)
+	if err != nil {
+		return err
+	}
+	log.Printf("reserved %s of %d huge pages of %dKiB", strings.TrimSpace(string(b)), n, size)
+	return nil
+}
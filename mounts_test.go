@@ -0,0 +1,137 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSortMounts(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mounts []Mount
+		want   []string
+	}{{
+		name:   "parents first",
+		mounts: []Mount{{Target: "/a/b/c"}, {Target: "/a/b"}, {Target: "/a"}},
+		want:   []string{"/a", "/a/b", "/a/b/c"},
+	}, {
+		name:   "declaration order among siblings",
+		mounts: []Mount{{Target: "/b"}, {Target: "/a"}, {Target: "/c"}},
+		want:   []string{"/b", "/a", "/c"},
+	}, {
+		name:   "unclean paths",
+		mounts: []Mount{{Target: "/a//b/"}, {Target: "/a/"}},
+		want:   []string{"/a/", "/a//b/"},
+	}, {
+		name:   "order comes first",
+		mounts: []Mount{{Target: "/a", Order: 1}, {Target: "/z/y", Order: -1}, {Target: "/b"}},
+		want:   []string{"/z/y", "/b", "/a"},
+	}, {
+		name:   "an order can put a child before its parent",
+		mounts: []Mount{{Target: "/a"}, {Target: "/a/b", Order: -1}},
+		want:   []string{"/a/b", "/a"},
+	}, {
+		name: "empty",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			orig := slices.Clone(tc.mounts)
+			var got []string
+			for _, m := range sortMounts(tc.mounts) {
+				got = append(got, m.Target)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("sortMounts() = %v, want %v", got, tc.want)
+			}
+			if !slices.EqualFunc(tc.mounts, orig, func(a, b Mount) bool { return a.Target == b.Target }) {
+				t.Errorf("sortMounts() reordered its argument to %v", tc.mounts)
+			}
+		})
+	}
+}
+
+func TestMountAllNested(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting needs root")
+	}
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "a", "b")
+	// The child is listed first, and neither mount point exists yet.
+	if err := mountAll([]Mount{
+		{Source: "tmpfs", Target: b, Type: "tmpfs"},
+		{Source: "tmpfs", Target: a, Type: "tmpfs"},
+	}, false); err != nil {
+		t.Fatalf("mountAll() = %v", err)
+	}
+	t.Cleanup(func() {
+		for _, target := range []string{b, a} {
+			if err := unix.Unmount(target, 0); err != nil {
+				t.Errorf("unmounting %s: %v", target, err)
+			}
+		}
+	})
+
+	// Each is a filesystem of its own, with the child visible on top of the
+	// parent rather than hidden underneath it.
+	dev := func(path string) uint64 {
+		var st unix.Stat_t
+		if err := unix.Stat(path, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st.Dev
+	}
+	if dev(a) == dev(root) {
+		t.Fatalf("%s was not mounted", a)
+	}
+	if dev(b) == dev(a) || dev(b) == dev(root) {
+		t.Errorf("%s was not mounted on top of %s", b, a)
+	}
+}
+
+// The entrypoint expects its mounts, so any that can't be mounted is an error
+// rather than being skipped.
+func TestMountAllFailure(t *testing.T) {
+	root := t.TempDir()
+	for _, tc := range []struct {
+		name   string
+		mount  Mount
+		strict bool
+		root   bool
+	}{{
+		name:   "invalid options when strict",
+		mount:  Mount{Source: "tmpfs", Target: filepath.Join(root, "strict"), Type: "tmpfs", Options: "size"},
+		strict: true,
+	}, {
+		name:  "fsck of a missing device",
+		mount: Mount{Source: filepath.Join(root, "missing"), Target: filepath.Join(root, "fsck"), Type: "ext4", Fsck: true},
+	}, {
+		name:  "mount point can't be created",
+		mount: Mount{Source: "tmpfs", Target: "/proc/self/wolfinit/mnt", Type: "tmpfs"},
+	}, {
+		name:  "mount fails",
+		mount: Mount{Source: "bogus", Target: filepath.Join(root, "bogus"), Type: "wolfinit-bogus"},
+		root:  true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.root && os.Geteuid() != 0 {
+				t.Skip("mounting needs root")
+			}
+			if err := mountAll([]Mount{tc.mount}, tc.strict); err == nil {
+				t.Error("mountAll() = nil, wanted an error")
+			}
+		})
+	}
+	// Nothing was mounted before the strict check failed.
+	if _, err := os.Stat(filepath.Join(root, "strict")); !os.IsNotExist(err) {
+		t.Errorf("strict mount point: %v, wanted it not to exist", err)
+	}
+}
@@ -0,0 +1,125 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// newNetworkNamespace creates a network namespace for the entrypoint, moves
+// the configured interface into it, and configures that there: its address,
+// a default route via the gateway, and the namespace's own loopback. None of
+// init's interfaces (in links) may be moved, since they would lose their
+// configuration. The namespace is returned for the entrypoint to enter.
+func newNetworkNamespace(cfg NetworkNamespace, links []netlink.Link) (namespace, error) {
+	if cfg.Interface == "" {
+		return namespace{}, fmt.Errorf("an interface to move into it is required")
+	}
+	for _, l := range links {
+		if l.Attrs().Name == cfg.Interface {
+			return namespace{}, fmt.Errorf("interface %s is used by init", cfg.Interface)
+		}
+	}
+	link, err := netlink.LinkByName(cfg.Interface)
+	if err != nil {
+		return namespace{}, fmt.Errorf("finding interface %s: %w", cfg.Interface, err)
+	}
+	var addr *netlink.Addr
+	if cfg.Address != "" {
+		if addr, err = netlink.ParseAddr(cfg.Address); err != nil {
+			return namespace{}, fmt.Errorf("parsing address: %w", err)
+		}
+	}
+	var gw net.IP
+	if cfg.Gateway != "" {
+		if gw = net.ParseIP(cfg.Gateway); gw == nil {
+			return namespace{}, fmt.Errorf("invalid gateway %q", cfg.Gateway)
+		}
+	}
+
+	// Creating a namespace moves the calling thread into it, so do that on
+	// a dedicated thread, which is thrown away afterwards (by never
+	// unlocking it) so that init itself stays where it is.
+	type created struct {
+		ns  netns.NsHandle
+		err error
+	}
+	c := make(chan created, 1)
+	go func() {
+		runtime.LockOSThread()
+		ns, err := netns.New()
+		c <- created{ns, err}
+	}()
+	r := <-c
+	if r.err != nil {
+		return namespace{}, fmt.Errorf("creating network namespace: %w", r.err)
+	}
+	// Keep it open, so that it outlives the thread (and survives restarts of
+	// the entrypoint).
+	f := os.NewFile(uintptr(r.ns), "network namespace")
+	ns := namespace{path: "a new network namespace", f: f, nstype: unix.CLONE_NEWNET}
+
+	if err := configureNetworkNamespace(r.ns, link, addr, gw); err != nil {
+		f.Close()
+		return namespace{}, err
+	}
+	log.Printf("moved %s into a new network namespace for the entrypoint", cfg.Interface)
+	return ns, nil
+}
+
+// configureNetworkNamespace moves the link into the namespace, and then
+// configures it there through a netlink handle in the namespace.
+func configureNetworkNamespace(ns netns.NsHandle, link netlink.Link, addr *netlink.Addr, gw net.IP) error {
+	name := link.Attrs().Name
+	// ip link set <link> netns <ns>
+	if err := netlink.LinkSetNsFd(link, int(ns)); err != nil {
+		return fmt.Errorf("moving %s into the network namespace: %w", name, err)
+	}
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	lo, err := h.LinkByName("lo")
+	if err != nil {
+		return fmt.Errorf("finding lo in the network namespace: %w", err)
+	} else if err := h.LinkSetUp(lo); err != nil {
+		return fmt.Errorf("setting lo up in the network namespace: %w", err)
+	}
+
+	// The link has a new handle (and maybe index) in its new namespace.
+	if link, err = h.LinkByName(name); err != nil {
+		return fmt.Errorf("finding %s in the network namespace: %w", name, err)
+	}
+	if addr != nil {
+		// ip addr add <addr> dev <link>
+		if err := h.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("adding address %s to %s: %w", addr, name, err)
+		}
+		log.Printf("added address %s to %s in the network namespace", addr, name)
+	}
+	if err := h.LinkSetUp(link); err != nil {
+		return fmt.Errorf("setting %s up in the network namespace: %w", name, err)
+	}
+	if gw != nil {
+		// ip route add default via <gw> dev <link>
+		if err := h.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: gw}); err != nil {
+			return fmt.Errorf("adding default route via %s: %w", gw, err)
+		}
+		log.Printf("added default route via %s on %s in the network namespace", gw, name)
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/u-root/u-root/pkg/dhclient"
+	"github.com/vishvananda/netlink"
+)
+
+// interfaceWaitInterval is how often the interfaces are listed again while
+// waiting for the expected one to appear.
+const interfaceWaitInterval = 100 * time.Millisecond
+
+// findInterfaces returns the interfaces to configure. An interface with the
+// given MAC address takes precedence, followed by one with the given name.
+// Since a NIC may be registered shortly after we start, we wait up to timeout
+// for one of those to appear. When neither is given or matches, this falls
+// back to the veth interfaces supporting broadcast and multi-cast, or only the
+// 1st of them unless all is set.
+func findInterfaces(name, mac string, all bool, timeout time.Duration) ([]netlink.Link, error) {
+	var hw net.HardwareAddr
+	if mac != "" {
+		var err error
+		if hw, err = net.ParseMAC(mac); err != nil {
+			return nil, fmt.Errorf("parsing interface MAC: %w", err)
+		}
+	}
+	ll, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	if hw != nil || name != "" {
+		start := time.Now()
+		waited := false
+		if expectedInterface(ll, hw, name) == nil && timeout > 0 {
+			log.Printf("waiting up to %v for the interface to appear", timeout)
+			waited = true
+		}
+		for expectedInterface(ll, hw, name) == nil && time.Since(start) < timeout {
+			time.Sleep(interfaceWaitInterval)
+			if ll, err = netlink.LinkList(); err != nil {
+				return nil, err
+			}
+		}
+		if link := expectedInterface(ll, hw, name); link != nil {
+			if waited {
+				log.Printf("interface %s appeared after %v", link.Attrs().Name, time.Since(start).Round(time.Millisecond))
+			}
+			return []netlink.Link{link}, nil
+		}
+		if hw != nil {
+			log.Printf("no interface has MAC %s", hw)
+		}
+		if name != "" {
+			log.Printf("no interface is named %s", name)
+		}
+		log.Printf("falling back to detecting the interface")
+	}
+
+	var links []netlink.Link
+	for _, link := range ll {
+		// This is to mirror this:
+		// ip -o link show | grep '<BROADCAST,MULTICAST>'
+		attr := link.Attrs()
+		if attr.Flags&net.FlagBroadcast != net.FlagBroadcast {
+			continue
+		} else if attr.Flags&net.FlagMulticast != net.FlagMulticast {
+			continue
+		}
+		links = append(links, link)
+		if !all {
+			break
+		}
+	}
+	return links, nil
+}
+
+// expectedInterface returns the interface with the MAC address, or failing
+// that the name, or nil if there is none.
+func expectedInterface(ll []netlink.Link, hw net.HardwareAddr, name string) netlink.Link {
+	if hw != nil {
+		for _, link := range ll {
+			if bytes.Equal(link.Attrs().HardwareAddr, hw) {
+				return link
+			}
+		}
+	}
+	if name != "" {
+		for _, link := range ll {
+			if link.Attrs().Name == name {
+				return link
+			}
+		}
+	}
+	return nil
+}
+
+// disableIPv6 turns off IPv6 on the link, i.e.
+// sysctl net.ipv6.conf.<link>.disable_ipv6=1
+// which must be done before it is brought up, to keep it from getting any
+// IPv6 addresses.
+func disableIPv6(link netlink.Link) error {
+	name := link.Attrs().Name
+	path := filepath.Join("/proc/sys/net/ipv6/conf", name, "disable_ipv6")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		log.Printf("not disabling IPv6 on %s, which the kernel doesn't support", name)
+		return nil
+	}
+	if err := os.WriteFile(path, []byte("1\n"), 0644); err != nil {
+		return err
+	}
+	log.Printf("disabled IPv6 on %s", name)
+	return nil
+}
+
+const (
+	defaultLinkUpRetries = 3
+	linkUpRetryInterval  = 500 * time.Millisecond
+)
+
+// setLinkUp brings the link up, retrying up to retries times, since some
+// drivers (e.g. virtio-net) transiently fail before the device has finished
+// initializing.
+func setLinkUp(link netlink.Link, retries int) error {
+	name := link.Attrs().Name
+	for i := 0; ; i++ {
+		err := netlink.LinkSetUp(link)
+		if err == nil {
+			return nil
+		} else if i >= retries {
+			return err
+		}
+		log.Printf("failed to set %s up, retrying in %v (%d/%d): %v", name, linkUpRetryInterval, i+1, retries, err)
+		time.Sleep(linkUpRetryInterval)
+	}
+}
+
+// lease is a DHCP lease that was obtained for a link.
+type lease struct {
+	link netlink.Link
+	// The default gateway for the link, if the lease provided one.
+	gateway net.IP
+}
+
+// leaseGateway returns the default gateway from a DHCPv4 lease, if any.
+func leaseGateway(l dhclient.Lease) net.IP {
+	p, ok := l.(*dhclient.Packet4)
+	if !ok {
+		return nil
+	}
+	// As when configuring the lease, classless static routes take priority
+	// over the router option (RFC 3442).
+	if routes := p.P.ClasslessStaticRoute(); routes != nil {
+		for _, r := range routes {
+			if ones, _ := r.Dest.Mask.Size(); ones == 0 {
+				return r.Router
+			}
+		}
+		return nil
+	}
+	if gw := p.P.Router(); len(gw) > 0 {
+		return gw[0]
+	}
+	return nil
+}
+
+// configureDHCP configures the links via DHCP, and returns the leases that
+// were obtained, and how many of them the links were configured with. When
+// concurrency is positive, at most that many links make
+// requests at once. See configureLease for resolvConfFallback.
+// Modeled after the u-root configureAll function:
+// https://github.com/u-root/u-root/blob/0c0df672/cmds/core/dhclient/dhclient.go#L67
+func configureDHCP(ctx context.Context, links []netlink.Link, concurrency int, resolvConfFallback bool) ([]lease, int) {
+	c := dhclient.Config{
+		Timeout: 10 * time.Second,
+		Retries: 3,
+		V4ServerAddr: &net.UDPAddr{
+			IP:   net.IPv4bcast,
+			Port: dhcpv4.ServerPort,
+		},
+		LogLevel: dhclient.LogInfo, // There is nothing lower than info.
+	}
+	var leases []lease
+	configured := 0
+	r := sendDHCPRequests(ctx, links, c, concurrency)
+	for result := range r {
+		if result.Err != nil {
+			log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, result.Err)
+			continue
+		}
+		leases = append(leases, lease{link: result.Interface, gateway: leaseGateway(result.Lease)})
+		if err := configureLease(result.Lease, resolvConfFallback); err != nil {
+			log.Printf("Could not configure %s for %s: %v", result.Interface.Attrs().Name, result.Protocol, err)
+			continue
+		}
+		configured++
+		// log.Printf("Configured %s with %s", result.Interface.Attrs().Name, result.Lease)
+	}
+	log.Printf("Finished trying to configure all interfaces.")
+	return leases, configured
+}
+
+// sendDHCPRequests is dhclient.SendRequests, except that when concurrency is
+// positive (and less than the number of links) the links are handed out to a
+// pool of that many workers, each requesting a lease for one link at a time.
+// The results of every worker are merged into the returned channel.
+func sendDHCPRequests(ctx context.Context, links []netlink.Link, c dhclient.Config, concurrency int) chan *dhclient.Result {
+	if concurrency <= 0 || concurrency >= len(links) {
+		return dhclient.SendRequests(ctx, links,
+			true /* ipv4 */, false /* ipv6 */, c, 10*time.Second)
+	}
+	log.Printf("requesting DHCP leases for %d interfaces, %d at a time", len(links), concurrency)
+	todo := make(chan netlink.Link, len(links))
+	for _, link := range links {
+		todo <- link
+	}
+	close(todo)
+
+	results := make(chan *dhclient.Result, len(links))
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range todo {
+				log.Printf("requesting a DHCP lease for %s", link.Attrs().Name)
+				for result := range dhclient.SendRequests(ctx, []netlink.Link{link},
+					true /* ipv4 */, false /* ipv6 */, c, 10*time.Second) {
+					results <- result
+				}
+				log.Printf("finished requesting a DHCP lease for %s", link.Attrs().Name)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// defaultRouteMetricStep is the gap between the metrics of the default routes
+// via each interface.
+const defaultRouteMetricStep = 100
+
+// setDefaultRoutes installs a default route via each leased interface, and
+// returns the primary one. Each lease replaces the default route of the one
+// configured before it, so without this the egress interface depends on the
+// order the leases arrived in. Instead, the primary interface (the named one
+// if it obtained a lease, and otherwise the first by name) gets metric 0 and
+// the others increasing metrics in name order.
+func setDefaultRoutes(leases []lease, primary string) netlink.Link {
+	if len(leases) == 0 {
+		return nil
+	}
+	leases = slices.Clone(leases)
+	slices.SortStableFunc(leases, func(a, b lease) int {
+		switch {
+		case a.link.Attrs().Name == primary:
+			return -1
+		case b.link.Attrs().Name == primary:
+			return 1
+		default:
+			return strings.Compare(a.link.Attrs().Name, b.link.Attrs().Name)
+		}
+	})
+	log.Printf("using %s as the primary interface", leases[0].link.Attrs().Name)
+	for i, l := range leases {
+		if l.gateway == nil {
+			continue
+		}
+		r := &netlink.Route{
+			LinkIndex: l.link.Attrs().Index,
+			Gw:        l.gateway,
+			Priority:  i * defaultRouteMetricStep,
+		}
+		if err := netlink.RouteReplace(r); err != nil {
+			log.Printf("failed to set default route via %s on %s: %v", l.gateway, l.link.Attrs().Name, err)
+			continue
+		}
+		log.Printf("default route via %s on %s has metric %d", l.gateway, l.link.Attrs().Name, r.Priority)
+	}
+	return leases[0].link
+}
+
+// The policies for what to do when no interface obtains a DHCP lease, or
+// none could be configured with the lease it obtained.
+const (
+	// Proceed to start the entrypoint anyway (the default).
+	dhcpContinue = "continue"
+	// Try again with exponential backoff, before continuing anyway.
+	dhcpRetry = "retry"
+	// Fail, which powers off the VM.
+	dhcpFatal = "fatal"
+)
+
+const (
+	dhcpRetries      = 5
+	dhcpRetryBackoff = time.Second
+)
+
+// checkDHCPPolicy returns the policy, or the default if it is unknown.
+func checkDHCPPolicy(setting, policy string) string {
+	switch policy {
+	case "", dhcpContinue, dhcpRetry, dhcpFatal:
+		return policy
+	default:
+		log.Printf("unknown %s policy %q, using %q", setting, policy, dhcpContinue)
+		return dhcpContinue
+	}
+}
+
+// runDHCP configures the links via DHCP and returns the leases obtained. The
+// given policy is applied if none of them obtain a lease, and configurePolicy
+// if they do but none could be configured with it (e.g. since its address
+// couldn't be added), which distinguishes one of several interfaces failing
+// from all of them failing.
+func runDHCP(ctx context.Context, links []netlink.Link, policy, configurePolicy string, concurrency int, resolvConfFallback bool) ([]lease, error) {
+	policy = checkDHCPPolicy("dhcp-failure", policy)
+	configurePolicy = checkDHCPPolicy("dhcp-configure-failure", configurePolicy)
+
+	// attempt returns the leases, and when everything failed the problem
+	// and the policy that applies to it.
+	attempt := func() ([]lease, string, string) {
+		leases, configured := configureDHCP(ctx, links, concurrency, resolvConfFallback)
+		switch {
+		case len(leases) == 0:
+			return nil, "no interface obtained a DHCP lease", policy
+		case configured == 0:
+			return leases, "no interface could be configured with its DHCP lease", configurePolicy
+		}
+		return leases, "", ""
+	}
+
+	leases, problem, p := attempt()
+	if problem == "" {
+		return leases, nil
+	}
+	if p == dhcpRetry {
+		backoff := dhcpRetryBackoff
+		for i := 1; i <= dhcpRetries; i++ {
+			log.Printf("%s, retrying in %v (%d/%d)", problem, backoff, i, dhcpRetries)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			if leases, problem, p = attempt(); problem == "" {
+				return leases, nil
+			} else if p != dhcpRetry {
+				// It failed differently this time, e.g. got a lease but
+				// couldn't configure it.
+				break
+			}
+			backoff *= 2
+		}
+	}
+	if p == dhcpFatal {
+		return nil, errors.New(problem)
+	}
+	if len(leases) == 0 {
+		log.Printf("%s, continuing without networking", problem)
+	} else {
+		log.Printf("%s, continuing anyway", problem)
+	}
+	return leases, nil
+}
+
+// addNeighbors installs the static neighbor (ARP) entries, on the named
+// interface or def if none is named. Invalid entries are logged and skipped.
+func addNeighbors(neighbors []Neighbor, def netlink.Link) {
+	for _, n := range neighbors {
+		ip := net.ParseIP(n.IP)
+		if ip == nil {
+			log.Printf("ignoring neighbor with invalid IP %q", n.IP)
+			continue
+		}
+		mac, err := net.ParseMAC(n.MAC)
+		if err != nil {
+			log.Printf("ignoring neighbor %s with invalid MAC: %v", n.IP, err)
+			continue
+		}
+		link := def
+		if n.Interface != "" {
+			if link, err = netlink.LinkByName(n.Interface); err != nil {
+				log.Printf("failed to find interface %s for neighbor %s: %v", n.Interface, n.IP, err)
+				continue
+			}
+		}
+		family := netlink.FAMILY_V4
+		if ip.To4() == nil {
+			family = netlink.FAMILY_V6
+		}
+		// ip neigh add <ip> lladdr <mac> dev <link> nud permanent
+		if err := netlink.NeighAdd(&netlink.Neigh{
+			LinkIndex:    link.Attrs().Index,
+			Family:       family,
+			State:        netlink.NUD_PERMANENT,
+			IP:           ip,
+			HardwareAddr: mac,
+		}); err != nil {
+			log.Printf("failed to add neighbor %s (%s) on %s: %v", ip, mac, link.Attrs().Name, err)
+			continue
+		}
+		log.Printf("added neighbor %s (%s) on %s", ip, mac, link.Attrs().Name)
+	}
+}
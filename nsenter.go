@@ -0,0 +1,87 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// namespaceNames are the names of the namespace types, as in /proc/<pid>/ns.
+var namespaceNames = map[int]string{
+	unix.CLONE_NEWCGROUP: "cgroup",
+	unix.CLONE_NEWIPC:    "ipc",
+	unix.CLONE_NEWNET:    "net",
+	unix.CLONE_NEWNS:     "mnt",
+	unix.CLONE_NEWPID:    "pid",
+	unix.CLONE_NEWTIME:   "time",
+	unix.CLONE_NEWUSER:   "user",
+	unix.CLONE_NEWUTS:    "uts",
+}
+
+// namespace is an existing namespace for the entrypoint to join.
+type namespace struct {
+	path   string
+	f      *os.File
+	nstype int
+}
+
+// openNamespaces opens the namespace files (e.g. /proc/<pid>/ns/net), and
+// checks that each can be joined. They are kept open, so that a restarted
+// entrypoint joins the same namespaces even if they have no other members
+// left.
+func openNamespaces(paths []string) ([]namespace, error) {
+	var nss []namespace
+	seen := make(map[int]string, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrPermission) {
+			return nil, fmt.Errorf("not allowed to open namespace %s: %w", path, err)
+		} else if err != nil {
+			return nil, err
+		}
+		nstype, err := unix.IoctlRetInt(int(f.Fd()), unix.NS_GET_NSTYPE)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s is not a namespace: %w", path, err)
+		}
+		name := namespaceNames[nstype]
+		switch nstype {
+		case unix.CLONE_NEWNS, unix.CLONE_NEWUSER, unix.CLONE_NEWTIME:
+			// The kernel only lets a single-threaded process join these,
+			// and init never is one (as a Go program).
+			f.Close()
+			return nil, fmt.Errorf("%s is a %s namespace, which can't be entered", path, name)
+		}
+		if other, ok := seen[nstype]; ok {
+			f.Close()
+			return nil, fmt.Errorf("%s and %s are both %s namespaces", other, path, name)
+		}
+		seen[nstype] = path
+		nss = append(nss, namespace{path: path, f: f, nstype: nstype})
+		log.Printf("the entrypoint will enter the %s namespace %s", name, path)
+	}
+	return nss, nil
+}
+
+// enterNamespaces moves the calling thread into the namespaces, so that a
+// process forked from it starts in them. This must be done on a thread given
+// to startOnThread, first, while it still has the CAP_SYS_ADMIN it needs.
+func enterNamespaces(nss []namespace) error {
+	for _, ns := range nss {
+		if err := unix.Setns(int(ns.f.Fd()), ns.nstype); errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("not allowed to enter namespace %s (this needs CAP_SYS_ADMIN over it): %w", ns.path, err)
+		} else if err != nil {
+			return fmt.Errorf("entering namespace %s: %w", ns.path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestOpenNamespaces(t *testing.T) {
+	if _, err := os.Stat("/proc/self/ns/net"); err != nil {
+		t.Skipf("no namespaces to open: %v", err)
+	}
+	for _, tc := range []struct {
+		name    string
+		paths   []string
+		want    []int
+		wantErr bool
+	}{
+		{name: "net and uts", paths: []string{"/proc/self/ns/net", "/proc/self/ns/uts"}, want: []int{unix.CLONE_NEWNET, unix.CLONE_NEWUTS}},
+		{name: "mount", paths: []string{"/proc/self/ns/mnt"}, wantErr: true},
+		{name: "user", paths: []string{"/proc/self/ns/user"}, wantErr: true},
+		{name: "time", paths: []string{"/proc/self/ns/time"}, wantErr: true},
+		{name: "duplicate", paths: []string{"/proc/self/ns/net", "/proc/thread-self/ns/net"}, wantErr: true},
+		{name: "not a namespace", paths: []string{"/proc/self/status"}, wantErr: true},
+		{name: "missing", paths: []string{"/proc/self/ns/nope"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nss, err := openNamespaces(tc.paths)
+			for _, ns := range nss {
+				defer ns.f.Close()
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("openNamespaces(%v) succeeded, want an error", tc.paths)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("openNamespaces(%v) = %v", tc.paths, err)
+			}
+			if len(nss) != len(tc.want) {
+				t.Fatalf("openNamespaces(%v) opened %d, want %d", tc.paths, len(nss), len(tc.want))
+			}
+			for i, ns := range nss {
+				if ns.nstype != tc.want[i] || ns.path != tc.paths[i] {
+					t.Errorf("namespace %d = %s (%#x), want %s (%#x)", i, ns.path, ns.nstype, tc.paths[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
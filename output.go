@@ -0,0 +1,94 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// prefixWriter writes the output of the entrypoint to w, starting each line
+// with a timestamp in the given layout (when set) and then prefix. Output is
+// passed on as soon as it is written rather than buffered until the end of
+// the line, so a partial line (e.g. a prompt) shows up at once, and we only
+// need to remember whether the next byte starts a line. Each line is stamped
+// with when its first byte was written.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	layout string
+
+	mu sync.Mutex
+	// Whether the last byte written ended a line.
+	midLine bool
+	// Whether binary output was seen, after which everything is passed
+	// through unchanged.
+	raw bool
+}
+
+// newPrefixWriter returns a writer that prefixes each line written to w.
+func newPrefixWriter(w io.Writer, prefix, layout string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix), layout: layout}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	// Text doesn't contain NULs, so anything that does is binary, which
+	// inserting into would corrupt.
+	if !pw.raw && bytes.IndexByte(p, 0) >= 0 {
+		log.Printf("entrypoint output looks binary, no longer prefixing it")
+		pw.raw = true
+	}
+	if pw.raw {
+		return pw.w.Write(p)
+	}
+
+	// Build the whole write at once, so that each write to the console is
+	// still a single one.
+	var buf bytes.Buffer
+	buf.Grow(len(p) + len(pw.prefix))
+	for rest := p; len(rest) != 0; {
+		if !pw.midLine {
+			if pw.layout != "" {
+				buf.WriteString(time.Now().Format(pw.layout))
+				buf.WriteByte(' ')
+			}
+			buf.Write(pw.prefix)
+		}
+		line, after, found := bytes.Cut(rest, []byte{'\n'})
+		buf.Write(line)
+		if found {
+			buf.WriteByte('\n')
+		}
+		pw.midLine = !found
+		rest = after
+	}
+	if _, err := pw.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush passes on the flush to the writer being prefixed.
+func (pw *prefixWriter) flush() {
+	flushOutput(pw.w)
+}
+
+// flushOutput has each of the writers that holds on to partial lines write
+// them out, e.g. once the entrypoint has exited.
+func flushOutput(ws ...io.Writer) {
+	for _, w := range ws {
+		if f, ok := w.(interface{ flush() }); ok {
+			f.flush()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// applyChowns changes the ownership of the configured paths, defaulting to
+// the run-as user and group. Failures are logged per path, and don't stop the
+// others from being changed.
+func applyChowns(specs []ChownSpec, uid, gid int) {
+	for _, spec := range specs {
+		u, g := uid, gid
+		if spec.UID != nil {
+			u = *spec.UID
+		}
+		if spec.GID != nil {
+			g = *spec.GID
+		}
+		chown := func(path string) {
+			// Symlinks themselves are changed, rather than what they point
+			// to, so that a link can't redirect us elsewhere.
+			if err := os.Lchown(path, u, g); err != nil {
+				log.Printf("failed to chown %s: %v", path, err)
+			}
+		}
+		if !spec.Recursive {
+			chown(spec.Path)
+			log.Printf("changed the owner of %s to %d:%d", spec.Path, u, g)
+			continue
+		}
+		err := filepath.WalkDir(spec.Path, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				log.Printf("failed to walk %s: %v", path, err)
+				return nil
+			}
+			chown(path)
+			return nil
+		})
+		if err != nil {
+			log.Printf("failed to chown %s: %v", spec.Path, err)
+		}
+		log.Printf("changed the owner of %s and its contents to %d:%d", spec.Path, u, g)
+	}
+}
+
+// parseMode parses an octal mode, e.g. "0750" or "1777".
+func parseMode(s string) (uint32, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("malformed mode %q: %w", s, err)
+	} else if m > 07777 {
+		return 0, fmt.Errorf("mode %q has bits other than permissions", s)
+	}
+	return uint32(m), nil
+}
+
+// validateChmods checks that every mode is a valid octal mode, so that a typo
+// is caught before anything is changed.
+func validateChmods(specs []ChmodSpec) error {
+	for _, spec := range specs {
+		if _, err := parseMode(spec.Mode); err != nil {
+			return fmt.Errorf("chmod of %s: %w", spec.Path, err)
+		}
+	}
+	return nil
+}
+
+// applyChmods changes the mode of the configured paths. Paths that don't
+// exist are skipped, and other failures are logged per path.
+func applyChmods(specs []ChmodSpec) {
+	for _, spec := range specs {
+		mode, err := parseMode(spec.Mode)
+		if err != nil {
+			log.Printf("failed to chmod %s: %v", spec.Path, err)
+			continue
+		}
+		// This is used rather than os.Chmod, which doesn't take setuid,
+		// setgid and sticky as octal bits.
+		if err := unix.Chmod(spec.Path, mode); errors.Is(err, os.ErrNotExist) {
+			log.Printf("not changing the mode of %s, which doesn't exist", spec.Path)
+		} else if err != nil {
+			log.Printf("failed to chmod %s: %v", spec.Path, err)
+		} else {
+			log.Printf("changed the mode of %s to %04o", spec.Path, mode)
+		}
+	}
+}
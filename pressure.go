@@ -0,0 +1,103 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultPressureMounts are the mounts whose usage is sampled, unless others
+// are configured.
+var defaultPressureMounts = []string{"/", "/tmp"}
+
+// readMeminfo returns the fields of /proc/meminfo, in KiB (or as a count, for
+// those without a unit).
+func readMeminfo() (map[string]uint64, error) {
+	b, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]uint64)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		// e.g. "MemAvailable:     123456 kB"
+		f := strings.Fields(s.Text())
+		if len(f) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		fields[strings.TrimSuffix(f[0], ":")] = v
+	}
+	return fields, s.Err()
+}
+
+// mib formats a number of bytes in MiB.
+func mib(n uint64) string {
+	return fmt.Sprintf("%dMiB", n>>20)
+}
+
+// samplePressure logs a single line of memory and disk usage.
+func samplePressure(mounts []string) {
+	parts := make([]string, 0, len(mounts)+1)
+	if mi, err := readMeminfo(); err != nil {
+		log.Printf("failed to read /proc/meminfo: %v", err)
+	} else {
+		total, available := mi["MemTotal"], mi["MemAvailable"]
+		parts = append(parts, fmt.Sprintf("memory used %s/%s", mib((total-available)<<10), mib(total<<10)))
+	}
+	for _, m := range mounts {
+		var st unix.Statfs_t
+		if err := unix.Statfs(m, &st); err != nil {
+			log.Printf("failed to stat %s: %v", m, err)
+			continue
+		}
+		size := st.Blocks * uint64(st.Bsize)
+		free := st.Bfree * uint64(st.Bsize)
+		parts = append(parts, fmt.Sprintf("%s used %s/%s", m, mib(size-free), mib(size)))
+	}
+	log.Printf("pressure: %s", strings.Join(parts, ", "))
+}
+
+// startPressureSampler logs memory usage and the disk usage of mounts every
+// interval, until the returned function is called. This gives a timeline to
+// look back on when the entrypoint is OOM killed or runs out of disk.
+func startPressureSampler(interval time.Duration, mounts []string) func() {
+	if len(mounts) == 0 {
+		mounts = defaultPressureMounts
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				samplePressure(mounts)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,110 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// securebitNames are the names of the securebits (see capabilities(7)),
+// indexed by bit.
+var securebitNames = []string{
+	"noroot", "noroot_locked",
+	"no_setuid_fixup", "no_setuid_fixup_locked",
+	"keep_caps", "keep_caps_locked",
+	"no_cap_ambient_raise", "no_cap_ambient_raise_locked",
+}
+
+// The securebits that interfere with giving a non-root entrypoint ambient
+// capabilities, which needs keep_caps across its setuid.
+const (
+	secbitKeepCapsLocked          = 1 << 5
+	secbitNoCapAmbientRaise       = 1 << 6
+	secbitNoCapAmbientRaiseLocked = 1 << 7
+)
+
+// privileges are the thread attributes from a privilege spec, which the
+// entrypoint inherits by being forked from that thread.
+type privileges struct {
+	securebits uintptr
+	noNewPrivs bool
+}
+
+// resolvePrivileges applies the spec's user, group and supplementary groups
+// to cred, and returns the capabilities to keep and the attributes to set on
+// the thread the entrypoint is started from.
+func resolvePrivileges(p Privileges, cred *syscall.Credential) ([]uintptr, *privileges, error) {
+	if p.UID != nil {
+		cred.Uid = uint32(*p.UID)
+	}
+	if p.GID != nil {
+		cred.Gid = uint32(*p.GID)
+	}
+	if p.Groups != nil {
+		cred.Groups = make([]uint32, 0, len(p.Groups))
+		for _, g := range p.Groups {
+			cred.Groups = append(cred.Groups, uint32(g))
+		}
+	}
+
+	var caps []uintptr
+	if p.Capabilities != nil {
+		var err error
+		if caps, err = parseCapabilities(p.Capabilities); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	priv := &privileges{noNewPrivs: p.NoNewPrivs}
+	for _, name := range p.Securebits {
+		n := strings.ReplaceAll(strings.ToLower(name), "-", "_")
+		found := false
+		for i, b := range securebitNames {
+			if b == n {
+				priv.securebits |= 1 << i
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("unknown securebit %q", name)
+		}
+	}
+	// Capabilities for a non-root entrypoint are raised as ambient ones
+	// after its setuid, which these bits prevent.
+	if caps != nil && cred.Uid != 0 && priv.securebits&(secbitKeepCapsLocked|secbitNoCapAmbientRaise|secbitNoCapAmbientRaiseLocked) != 0 {
+		return nil, nil, fmt.Errorf("securebits keep_caps_locked and no_cap_ambient_raise prevent keeping capabilities for uid %d", cred.Uid)
+	}
+	return caps, priv, nil
+}
+
+// apply sets the securebits and then no_new_privs on the calling thread. This
+// must be done on a thread given to startOnThread, after its bounding set is
+// dropped (which, like setting securebits, needs CAP_SETPCAP).
+//
+// Together with what the fork does before exec, this gives the order that
+// is easy to get wrong by hand: the bounding set, securebits and
+// no_new_privs first, while still root, then the supplementary groups, the
+// group and the user, and only then the ambient capabilities, which must be
+// raised after the user has changed.
+func (p *privileges) apply() error {
+	if p.securebits != 0 {
+		if err := unix.Prctl(unix.PR_SET_SECUREBITS, p.securebits, 0, 0, 0); err != nil {
+			return fmt.Errorf("setting securebits: %w", err)
+		}
+	}
+	if p.noNewPrivs {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("setting no_new_privs: %w", err)
+		}
+	}
+	return nil
+}
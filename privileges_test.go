@@ -0,0 +1,187 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestResolvePrivileges(t *testing.T) {
+	id := func(i int) *int { return &i }
+	for _, tc := range []struct {
+		name       string
+		p          Privileges
+		cred       syscall.Credential
+		want       syscall.Credential
+		caps       []uintptr
+		securebits uintptr
+		wantErr    bool
+	}{{
+		name: "empty keeps the run-as user",
+		cred: syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{10}},
+		want: syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{10}},
+	}, {
+		name: "user, group and groups",
+		p:    Privileges{UID: id(2000), GID: id(3000), Groups: []int{4000, 4001}},
+		cred: syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{10}},
+		want: syscall.Credential{Uid: 2000, Gid: 3000, Groups: []uint32{4000, 4001}},
+	}, {
+		name: "no groups at all",
+		p:    Privileges{Groups: []int{}},
+		cred: syscall.Credential{Groups: []uint32{10}},
+		want: syscall.Credential{Groups: []uint32{}},
+	}, {
+		name: "capabilities",
+		p:    Privileges{UID: id(1000), Capabilities: []string{"CAP_NET_BIND_SERVICE", "chown"}},
+		want: syscall.Credential{Uid: 1000},
+		caps: []uintptr{unix.CAP_NET_BIND_SERVICE, unix.CAP_CHOWN},
+	}, {
+		name:    "unknown capability",
+		p:       Privileges{Capabilities: []string{"cap_fly"}},
+		wantErr: true,
+	}, {
+		name:       "securebits",
+		p:          Privileges{Securebits: []string{"noroot", "No-Setuid-Fixup", "keep_caps_locked"}},
+		securebits: 1<<0 | 1<<2 | 1<<5,
+	}, {
+		name:    "unknown securebit",
+		p:       Privileges{Securebits: []string{"sticky"}},
+		wantErr: true,
+	}, {
+		name:    "securebits that prevent keeping capabilities",
+		p:       Privileges{UID: id(1000), Capabilities: []string{"net_raw"}, Securebits: []string{"no_cap_ambient_raise"}},
+		wantErr: true,
+	}, {
+		name:       "root keeps capabilities without ambient ones",
+		p:          Privileges{Capabilities: []string{"net_raw"}, Securebits: []string{"no_cap_ambient_raise"}},
+		caps:       []uintptr{unix.CAP_NET_RAW},
+		securebits: 1 << 6,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			cred := tc.cred
+			caps, priv, err := resolvePrivileges(tc.p, &cred)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("resolvePrivileges() succeeded, want an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("resolvePrivileges() = %v", err)
+			}
+			if cred.Uid != tc.want.Uid || cred.Gid != tc.want.Gid || !slices.Equal(cred.Groups, tc.want.Groups) || (cred.Groups == nil) != (tc.want.Groups == nil) {
+				t.Errorf("credential = %+v, want %+v", cred, tc.want)
+			}
+			if !slices.Equal(caps, tc.caps) {
+				t.Errorf("capabilities = %v, want %v", caps, tc.caps)
+			}
+			if priv.securebits != tc.securebits {
+				t.Errorf("securebits = %#x, want %#x", priv.securebits, tc.securebits)
+			}
+			if priv.noNewPrivs != tc.p.NoNewPrivs {
+				t.Errorf("noNewPrivs = %v, want %v", priv.noNewPrivs, tc.p.NoNewPrivs)
+			}
+		})
+	}
+}
+
+// effectiveCaps returns the calling thread's effective capabilities.
+func effectiveCaps(t *testing.T) uint64 {
+	t.Helper()
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}, &data[0]); err != nil {
+		t.Fatal(err)
+	}
+	return uint64(data[1].Effective)<<32 | uint64(data[0].Effective)
+}
+
+// TestPrivilegeOrder starts an entrypoint with a full privilege spec, which
+// only works out if each step happens in the right order (e.g. the bounding
+// set is dropped and securebits set while still root, and the ambient
+// capabilities raised after the user changes), and checks what it ends up
+// with.
+func TestPrivilegeOrder(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing privileges needs root")
+	}
+	id := func(i int) *int { return &i }
+	cred := &syscall.Credential{}
+	caps, priv, err := resolvePrivileges(Privileges{
+		UID:          id(1000),
+		GID:          id(1001),
+		Groups:       []int{1002},
+		Capabilities: []string{"net_bind_service"},
+		NoNewPrivs:   true,
+		Securebits:   []string{"noroot", "noroot_locked"},
+	}, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+
+	before := effectiveCaps(t)
+	var out strings.Builder
+	ep := &entrypoint{
+		args:   []string{"/bin/cat", "/proc/self/status"},
+		dir:    "/",
+		stdin:  stdin,
+		cred:   cred,
+		caps:   caps,
+		priv:   priv,
+		stdout: &out,
+		stderr: os.Stderr,
+	}
+	cmd, err := ep.launch(context.Background())
+	if err != nil {
+		t.Skipf("can't launch with the privileges here: %v", err)
+	}
+	err = cmd.Wait()
+	doneWaiting(cmd.Process.Pid)
+	if err != nil {
+		t.Fatalf("entrypoint failed: %v", err)
+	}
+
+	status := make(map[string]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			status[k] = strings.Join(strings.Fields(v), " ")
+		}
+	}
+	bit := "0000000000000400" // CAP_NET_BIND_SERVICE
+	for k, want := range map[string]string{
+		"Uid":        "1000 1000 1000 1000",
+		"Gid":        "1001 1001 1001 1001",
+		"Groups":     "1002",
+		"NoNewPrivs": "1",
+		"CapBnd":     bit,
+		"CapAmb":     bit,
+		"CapEff":     bit,
+		"CapPrm":     bit,
+	} {
+		if got := status[k]; got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+
+	// Init's own threads keep what they had.
+	if after := effectiveCaps(t); after != before {
+		t.Errorf("effective capabilities changed from %#x to %#x", before, after)
+	}
+	if v, err := unix.PrctlRetInt(unix.PR_GET_NO_NEW_PRIVS, 0, 0, 0, 0); err != nil || v != 0 {
+		t.Errorf("the test has no_new_privs = %d, %v", v, err)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// maxCommLen is the longest name the kernel keeps for a process (excluding
+// the terminating NUL).
+const maxCommLen = 15
+
+// setProcessTitle sets the name that ps and top show for init. This is the
+// equivalent of prctl(PR_SET_NAME), except that that only names the calling
+// thread, and writing /proc/self/comm names the main one whichever thread we
+// are on.
+func setProcessTitle(title string) {
+	if len(title) > maxCommLen {
+		log.Printf("truncating process title %q to %d bytes", title, maxCommLen)
+		title = title[:maxCommLen]
+	}
+	if err := os.WriteFile("/proc/self/comm", []byte(title), 0644); err != nil {
+		log.Printf("failed to set process title: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// normalizeProxyURL validates a proxy URL, defaulting its scheme to http as
+// most clients do, e.g. proxy.corp:3128 becomes http://proxy.corp:3128
+func normalizeProxyURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return "", fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("proxy %q has no host", s)
+	}
+	return u.String(), nil
+}
+
+// proxyEnvironment returns the environment variables for the proxy settings,
+// in both the upper and lower case spellings, since clients disagree on
+// which they read.
+func proxyEnvironment(cfg Proxy) (map[string]string, error) {
+	env := make(map[string]string, 6)
+	for _, p := range []struct{ name, value string }{
+		{"HTTP_PROXY", cfg.HTTP},
+		{"HTTPS_PROXY", cfg.HTTPS},
+	} {
+		if p.value == "" {
+			continue
+		}
+		v, err := normalizeProxyURL(p.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.ToLower(p.name), err)
+		}
+		env[p.name] = v
+		env[strings.ToLower(p.name)] = v
+	}
+	if len(cfg.NoProxy) != 0 {
+		hosts := make([]string, 0, len(cfg.NoProxy))
+		for _, h := range cfg.NoProxy {
+			if h = strings.TrimSpace(h); h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+		env["NO_PROXY"] = strings.Join(hosts, ",")
+		env["no_proxy"] = env["NO_PROXY"]
+	}
+	return env, nil
+}
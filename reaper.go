@@ -0,0 +1,135 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// reapMu serializes reaping with starting processes, so that a child
+	// can't be reaped before it has been recorded in waited.
+	reapMu sync.Mutex
+	// waited holds the pids of children that something else (i.e. cmd.Wait)
+	// is waiting for, which the reaper must leave alone.
+	waited = make(map[int]bool)
+	// reapNow asks the reaper to look for zombies again.
+	reapNow = make(chan struct{}, 1)
+)
+
+// startWaited calls start (e.g. cmd.Start), and records the started child as
+// one the reaper must leave for cmd.Wait. Once cmd.Wait has returned,
+// doneWaiting must be called.
+func startWaited(cmd *exec.Cmd, start func() error) error {
+	reapMu.Lock()
+	defer reapMu.Unlock()
+	if err := start(); err != nil {
+		return err
+	}
+	waited[cmd.Process.Pid] = true
+	return nil
+}
+
+// doneWaiting records that cmd.Wait has returned for pid.
+func doneWaiting(pid int) {
+	reapMu.Lock()
+	delete(waited, pid)
+	reapMu.Unlock()
+	// Other zombies may have been queued up behind this one.
+	select {
+	case reapNow <- struct{}{}:
+	default:
+	}
+}
+
+// runWaited is like cmd.Run, but safe to use alongside the reaper.
+func runWaited(cmd *exec.Cmd) error {
+	if err := startWaited(cmd, cmd.Start); err != nil {
+		return err
+	}
+	defer doneWaiting(cmd.Process.Pid)
+	return cmd.Wait()
+}
+
+// siginfoPid returns si_pid, which unix.Siginfo does not expose. It is the
+// first field of the union following si_signo, si_errno and si_code, and
+// that union is pointer-aligned.
+func siginfoPid(info *unix.Siginfo) int {
+	const ptrSize = unsafe.Sizeof(uintptr(0))
+	off := (3*unsafe.Sizeof(int32(0)) + ptrSize - 1) &^ (ptrSize - 1)
+	return int(*(*int32)(unsafe.Add(unsafe.Pointer(info), off)))
+}
+
+// defaultReapBatch is the most zombies reaped per wakeup of the reaper.
+const defaultReapBatch = 256
+
+// reapZombies reaps every exited child that nothing else is waiting for, up
+// to max of them (when it is positive). If it stops at max, it asks to be run
+// again, so that reapMu isn't held for the whole of an exit storm.
+func reapZombies(max int) {
+	reapMu.Lock()
+	defer reapMu.Unlock()
+	for reaped := 0; ; reaped++ {
+		if max > 0 && reaped >= max {
+			select {
+			case reapNow <- struct{}{}:
+			default:
+			}
+			return
+		}
+		// Peek at the next exited child without reaping it, so that we can
+		// skip those that cmd.Wait is responsible for.
+		var info unix.Siginfo
+		if err := unix.Waitid(unix.P_ALL, 0, &info, unix.WEXITED|unix.WNOHANG|unix.WNOWAIT, nil); err != nil {
+			if err != unix.ECHILD {
+				log.Printf("failed to wait for children: %v", err)
+			}
+			return
+		}
+		pid := siginfoPid(&info)
+		if pid == 0 || waited[pid] {
+			// Either nothing has exited, or the next zombie belongs to a
+			// cmd.Wait, which will poke us once it has collected it.
+			return
+		}
+		var ws unix.WaitStatus
+		if _, err := unix.Wait4(pid, &ws, unix.WNOHANG, nil); err != nil {
+			log.Printf("failed to reap %d: %v", pid, err)
+			return
+		}
+		log.Printf("reaped orphaned process %d (status %d)", pid, ws.ExitStatus())
+	}
+}
+
+// reapZombieProcesses reaps orphaned processes, which as PID 1 we inherit,
+// until ctx is cancelled, at most batch of them per wakeup. Any children that
+// have exited by then are reaped before it returns.
+func reapZombieProcesses(ctx context.Context, batch int) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGCHLD)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case <-ctx.Done():
+			// There's nothing left to starve by now.
+			reapZombies(0)
+			return
+		case <-sigs:
+		case <-reapNow:
+		}
+		reapZombies(batch)
+	}
+}
@@ -0,0 +1,194 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// becomeSubreaper makes the test inherit orphaned descendants, the way PID 1
+// would, so that they are left for the reaper.
+func becomeSubreaper(t *testing.T) {
+	t.Helper()
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		t.Skipf("can't become a subreaper: %v", err)
+	}
+}
+
+// hasChildren returns whether the test has any children left, exited or
+// not.
+func hasChildren() bool {
+	var info unix.Siginfo
+	return unix.Waitid(unix.P_ALL, 0, &info, unix.WEXITED|unix.WNOHANG|unix.WNOWAIT, nil) != unix.ECHILD
+}
+
+// waitReaped reaps until the test has no children left, failing if that
+// takes too long.
+func waitReaped(t *testing.T) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); hasChildren(); {
+		if time.Now().After(deadline) {
+			t.Fatal("children were not reaped")
+		}
+		reapZombies(0)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitZombie waits for an exited child to be left for reaping, without
+// reaping it.
+func waitZombie(t *testing.T, idtype, id int) *unix.Siginfo {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var info unix.Siginfo
+		if err := unix.Waitid(idtype, id, &info, unix.WEXITED|unix.WNOHANG|unix.WNOWAIT, nil); err != nil {
+			t.Fatalf("waitid: %v", err)
+		}
+		if siginfoPid(&info) != 0 {
+			return &info
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no child exited")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSiginfoPid(t *testing.T) {
+	cmd := exec.Command("/bin/true")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+
+	info := waitZombie(t, unix.P_PID, cmd.Process.Pid)
+	if got := siginfoPid(info); got != cmd.Process.Pid {
+		t.Errorf("siginfoPid() = %d, want %d", got, cmd.Process.Pid)
+	}
+	if info.Signo != int32(unix.SIGCHLD) {
+		t.Errorf("si_signo = %d, want SIGCHLD", info.Signo)
+	}
+}
+
+// leaveOrphan runs a command that leaves behind a child that exits shortly,
+// and waits for that child to be a zombie.
+func leaveOrphan(t *testing.T) {
+	t.Helper()
+	if err := runWaited(exec.Command("/bin/sh", "-c", "sleep 0.05 & exit 0")); err != nil {
+		t.Fatal(err)
+	}
+	waitZombie(t, unix.P_ALL, 0)
+}
+
+func TestReaperStopsOnCancel(t *testing.T) {
+	becomeSubreaper(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reapZombieProcesses(ctx, defaultReapBatch)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop after being cancelled")
+	}
+}
+
+func TestReaperDrainsOnCancel(t *testing.T) {
+	becomeSubreaper(t)
+	leaveOrphan(t)
+
+	// Even when it is told to stop before it has noticed the zombie, it is
+	// reaped on the way out.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reapZombieProcesses(ctx, defaultReapBatch)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop after being cancelled")
+	}
+	if hasChildren() {
+		t.Error("reaper stopped without reaping the orphan")
+	}
+}
+
+// leaveOrphans runs a command that leaves behind n children that exit
+// shortly.
+func leaveOrphans(t *testing.T, n int) {
+	t.Helper()
+	script := `i=0; while [ $i -lt $0 ]; do sleep 0.05 & i=$((i+1)); done`
+	if err := runWaited(exec.Command("/bin/sh", "-c", script, strconv.Itoa(n))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReapBatch(t *testing.T) {
+	becomeSubreaper(t)
+	select {
+	case <-reapNow:
+	default:
+	}
+	leaveOrphans(t, 50)
+	// Let them all exit.
+	time.Sleep(500 * time.Millisecond)
+
+	// Stopping at the cap leaves the rest for the next wakeup, which it
+	// asks for.
+	reapZombies(10)
+	select {
+	case <-reapNow:
+	default:
+		t.Error("reaper stopped at the cap without asking to run again")
+	}
+	if !hasChildren() {
+		t.Error("reaper reaped more than the cap")
+	}
+	reapZombies(0)
+	if hasChildren() {
+		t.Error("reaper without a cap left children behind")
+	}
+}
+
+func TestReaperExitStorm(t *testing.T) {
+	becomeSubreaper(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reapZombieProcesses(ctx, 8)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	start := time.Now()
+	leaveOrphans(t, 200)
+	// Only the running reaper reaps them.
+	for hasChildren() {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("reaper did not reap the orphans promptly")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Logf("reaped 200 orphans in %v", time.Since(start))
+}
@@ -0,0 +1,72 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/moby/sys/mount"
+	"github.com/u-root/u-root/pkg/dhclient"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+	// Where resolv.conf is kept when /etc is read-only, to bind mount over
+	// it. /dev is a tmpfs that we always mount writable.
+	resolvConfFallback = "/dev/.wolfinit-resolv.conf"
+)
+
+// configureLease configures the interface with the lease. When that fails
+// only because /etc is read-only, and fallback is set, the nameservers are
+// instead written to a file in /dev that is bind mounted over resolv.conf.
+func configureLease(l dhclient.Lease, fallback bool) error {
+	err := l.Configure()
+	if err == nil {
+		log.Printf("wrote %s for %s", resolvConfPath, l)
+		return nil
+	} else if !fallback || !errors.Is(err, syscall.EROFS) {
+		return err
+	}
+	p, ok := l.(*dhclient.Packet4)
+	if !ok {
+		return err
+	}
+	// The addresses and routes are configured before resolv.conf is
+	// written, so only it is left to do.
+	if err := os.WriteFile(resolvConfFallback, resolvConf(p.GatherDNSSettings()), 0644); err != nil {
+		return err
+	}
+	// The image's resolv.conf must exist, to be mounted over. The mount is
+	// writable, so any later leases write through it.
+	if err := mount.Mount(resolvConfFallback, resolvConfPath, "", "bind"); err != nil {
+		return fmt.Errorf("mounting over read-only %s: %w", resolvConfPath, err)
+	}
+	log.Printf("bind mounted %s over read-only %s for %s", resolvConfFallback, resolvConfPath, l)
+	return nil
+}
+
+// resolvConf formats the DNS settings the way dhclient writes them.
+func resolvConf(ns []net.IP, sl []string, domain string) []byte {
+	var b bytes.Buffer
+	if domain != "" {
+		fmt.Fprintf(&b, "domain %s\n", domain)
+	}
+	for _, ip := range ns {
+		fmt.Fprintf(&b, "nameserver %s\n", ip)
+	}
+	if sl != nil {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(sl, " "))
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,305 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/shlex"
+)
+
+// parseConfig parses the image configuration, first stripping comments if
+// they are allowed. When strict, unknown keys in our own settings are errors
+// (e.g. a misspelled setting, which would otherwise have no effect). The rest
+// of the configuration belongs to apko, which may add keys we don't know
+// about, so it is never checked.
+func parseConfig(b []byte, comments, strict bool) (*ImageConfiguration, error) {
+	if comments {
+		var err error
+		if b, err = stripComments(b); err != nil {
+			return nil, fmt.Errorf("stripping comments: %w", err)
+		}
+	}
+	var ic ImageConfiguration
+	if err := json.Unmarshal(b, &ic); err != nil {
+		return nil, err
+	}
+	if strict {
+		var raw struct {
+			Init json.RawMessage `json:"wolfinit"`
+		}
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return nil, err
+		}
+		if len(raw.Init) != 0 {
+			dec := json.NewDecoder(bytes.NewReader(raw.Init))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&InitConfiguration{}); err != nil {
+				return nil, fmt.Errorf("in wolfinit settings: %w", err)
+			}
+		}
+	}
+	return &ic, nil
+}
+
+// resolveEnvironment fills in the entrypoint's environment. Allowed kernel
+// command line overrides take precedence over variables passed through from
+// our own environment, then the environment of the run-as user, then the
+// global environment, and all take precedence over our defaults (e.g. PATH and
+// the proxy settings). With a clean environment, only the allowed variables
+// are then kept.
+func resolveEnvironment(ic *ImageConfiguration, user *User, params map[string]string) error {
+	if ic.Environment == nil {
+		ic.Environment = make(map[string]string, 1)
+	}
+	if user != nil {
+		for k, v := range user.Environment {
+			ic.Environment[k] = v
+		}
+	}
+
+	for _, k := range ic.Init.PassEnvironment {
+		if v, ok := os.LookupEnv(k); ok {
+			log.Printf("passing through %s from init's environment", k)
+			ic.Environment[k] = v
+		}
+	}
+
+	applyCmdlineEnvPrefixes(params, ic)
+	applyCmdlineEnv(params, ic)
+
+	// Ensure path is set in the environment. An empty PATH finds nothing, so
+	// unless asked to keep it, it is treated the same as an unset one.
+	switch path, ok := ic.Environment["PATH"]; {
+	case !ok:
+		ic.Environment["PATH"] = defaultPath
+	case path != "":
+	case ic.Init.EmptyPath == emptyPathKeep:
+		log.Printf("keeping the empty PATH, so only commands given as paths can be run")
+	case ic.Init.EmptyPath == "" || ic.Init.EmptyPath == emptyPathDefault:
+		log.Printf("PATH is empty, using the default %s", defaultPath)
+		ic.Environment["PATH"] = defaultPath
+	default:
+		return fmt.Errorf("unknown empty-path policy %q", ic.Init.EmptyPath)
+	}
+	// Ensure the locale is set in the environment.
+	locale := ic.Init.Locale
+	if locale == "" {
+		locale = defaultLocale
+	}
+	if _, ok := ic.Environment["LANG"]; !ok {
+		ic.Environment["LANG"] = locale
+	}
+	// Proxy settings are defaults too, so that an image can still override
+	// them for itself.
+	if ic.Init.Proxy != nil {
+		proxies, err := proxyEnvironment(*ic.Init.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy: %w", err)
+		}
+		for k, v := range proxies {
+			if _, ok := ic.Environment[k]; !ok {
+				ic.Environment[k] = v
+			}
+		}
+	}
+	if ic.Init.CleanEnvironment {
+		ic.Environment = allowedEnvironment(ic.Environment, ic.Init.AllowEnvironment)
+	}
+	return nil
+}
+
+// The policies for a PATH that is set, but empty.
+const (
+	// Use the default PATH, as though it wasn't set (the default).
+	emptyPathDefault = "default"
+	// Keep it empty.
+	emptyPathKeep = "keep"
+)
+
+// allowedEnvironment returns only the allowed variables from env, falling back
+// to init's own environment for those that env doesn't set.
+func allowedEnvironment(env map[string]string, allow []string) map[string]string {
+	clean := make(map[string]string, len(allow))
+	for _, k := range allow {
+		if v, ok := env[k]; ok {
+			clean[k] = v
+		} else if v, ok := os.LookupEnv(k); ok {
+			log.Printf("inheriting %s from init's environment", k)
+			clean[k] = v
+		}
+	}
+	log.Printf("starting from a clean environment with %d of %d allowed variables", len(clean), len(allow))
+	return clean
+}
+
+// The policies for environment variables whose names or values can't be
+// passed to the entrypoint.
+const (
+	// Fail, which powers off the VM (the default).
+	invalidEnvFail = "fail"
+	// Leave the variable out of the environment.
+	invalidEnvDrop = "drop"
+)
+
+// validateEnvironment checks that every environment variable can be passed
+// as a KEY=value string, i.e. that no key is empty or contains "=", and that
+// neither contains a NUL. Invalid variables are handled per the policy.
+func validateEnvironment(env map[string]string, policy string) error {
+	for k, v := range env {
+		var problem string
+		switch {
+		case k == "":
+			problem = "has an empty name"
+		case strings.Contains(k, "="):
+			problem = "has a name containing ="
+		case strings.ContainsRune(k, 0) || strings.ContainsRune(v, 0):
+			problem = "contains a NUL byte"
+		default:
+			continue
+		}
+		switch policy {
+		case invalidEnvDrop:
+			log.Printf("dropping environment variable %q, which %s", k, problem)
+			delete(env, k)
+		case "", invalidEnvFail:
+			return fmt.Errorf("environment variable %q %s", k, problem)
+		default:
+			return fmt.Errorf("unknown invalid-environment policy %q", policy)
+		}
+	}
+	return nil
+}
+
+// entrypointArgs splits the interpreter, entrypoint, cmd and any args file
+// into the argv to run.
+func entrypointArgs(ic *ImageConfiguration) ([]string, error) {
+	args := []string{}
+	if ic.Init.Interpreter != "" {
+		interp, err := shlex.Split(ic.Init.Interpreter)
+		if err != nil {
+			return nil, fmt.Errorf("splitting interpreter: %w", err)
+		}
+		args = append(args, interp...)
+	}
+	if ic.Entrypoint.Command != "" {
+		splitep, err := shlex.Split(ic.Entrypoint.Command)
+		if err != nil {
+			return nil, fmt.Errorf("splitting entrypoint: %w", err)
+		}
+		args = append(args, splitep...)
+	}
+	if ic.Cmd != "" {
+		splitcmd, err := shlex.Split(ic.Cmd)
+		if err != nil {
+			return nil, fmt.Errorf("splitting command: %w", err)
+		}
+		args = append(args, splitcmd...)
+	}
+	if ic.Init.ArgsFile != "" {
+		b, err := os.ReadFile(ic.Init.ArgsFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading args file: %w", err)
+		}
+		extra, err := shlex.Split(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("splitting args file %s: %w", ic.Init.ArgsFile, err)
+		}
+		args = append(args, extra...)
+	}
+	return args, nil
+}
+
+// resolvedConfig is the configuration as init would run it.
+type resolvedConfig struct {
+	*ImageConfiguration
+
+	UID  int      `json:"uid"`
+	GID  int      `json:"gid"`
+	Args []string `json:"args"`
+}
+
+// checkConfig is the entrypoint for running wolfinit with flags outside of a
+// VM (i.e. not as PID 1), where it only reads a configuration and prints it
+// as JSON after resolving it the way init would. It returns the exit code.
+func checkConfig(argv []string) int {
+	fs := flag.NewFlagSet("wolfinit", flag.ContinueOnError)
+	path := fs.String("config", "", "the image configuration to check, or - for stdin (.jsonc files may contain comments)")
+	comments := fs.Bool("comments", false, "allow comments whatever the extension, as wolfinit.config_comments does at boot")
+	strict := fs.Bool("strict", false, "reject unknown keys in the wolfinit settings")
+	if err := fs.Parse(argv); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "-config is required")
+		fs.Usage()
+		return 2
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+	if *path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(*path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config: %v\n", err)
+		return 1
+	}
+	ic, err := parseConfig(b, *comments || filepath.Ext(*path) == ".jsonc", *strict)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse config: %v\n", err)
+		return 1
+	}
+
+	// Don't add users to the /etc/passwd of whatever machine we're on.
+	policy := ic.Init.MissingRunAs
+	if policy == missingRunAsCreate {
+		policy = missingRunAsFail
+	}
+	uid, gid, user, err := resolveRunAs(ic.Accounts, policy, ic.Init.DuplicateRunAs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to resolve run-as user: %v\n", err)
+		return 1
+	}
+	if err := resolveEnvironment(ic, user, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to resolve environment: %v\n", err)
+		return 1
+	}
+	if err := validateEnvironment(ic.Environment, ic.Init.InvalidEnvironment); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid environment: %v\n", err)
+		return 1
+	}
+	args, err := entrypointArgs(ic)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build entrypoint: %v\n", err)
+		return 1
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "no entrypoint or cmd configured\n")
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resolvedConfig{ImageConfiguration: ic, UID: uid, GID: gid, Args: args}); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write config: %v\n", err)
+		return 1
+	}
+	return 0
+}
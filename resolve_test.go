@@ -0,0 +1,144 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveEnvironmentPath(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		policy  string
+		want    string
+		wantErr bool
+	}{{
+		name: "unset",
+		want: defaultPath,
+	}, {
+		name:   "unset, keeping empty",
+		policy: emptyPathKeep,
+		want:   defaultPath,
+	}, {
+		name: "empty",
+		env:  map[string]string{"PATH": ""},
+		want: defaultPath,
+	}, {
+		name:   "empty, defaulting",
+		env:    map[string]string{"PATH": ""},
+		policy: emptyPathDefault,
+		want:   defaultPath,
+	}, {
+		name:   "empty, keeping",
+		env:    map[string]string{"PATH": ""},
+		policy: emptyPathKeep,
+		want:   "",
+	}, {
+		name:    "empty, unknown policy",
+		env:     map[string]string{"PATH": ""},
+		policy:  "bogus",
+		wantErr: true,
+	}, {
+		name:   "set, keeping empty",
+		env:    map[string]string{"PATH": "/bin"},
+		policy: emptyPathKeep,
+		want:   "/bin",
+	}, {
+		name:   "set, unknown policy",
+		env:    map[string]string{"PATH": "/bin"},
+		policy: "bogus",
+		want:   "/bin",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			ic := &ImageConfiguration{Environment: tc.env}
+			ic.Init.EmptyPath = tc.policy
+			err := resolveEnvironment(ic, nil, nil)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("resolveEnvironment() = nil, wanted an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveEnvironment() = %v", err)
+			}
+			if got, ok := ic.Environment["PATH"]; !ok || got != tc.want {
+				t.Errorf("PATH = %q (set: %v), wanted %q", got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigStrict(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "known", config: `{"wolfinit": {"on-failure": "restart"}}`},
+		{name: "no settings", config: `{"work-dir": "/app"}`},
+		{name: "unknown setting", config: `{"wolfinit": {"on-faliure": "restart"}}`, wantErr: true},
+		{name: "unknown nested setting", config: `{"wolfinit": {"hardware-watchdog": {"devcie": "/dev/watchdog1"}}}`, wantErr: true},
+		// The rest belongs to apko, which may know keys we don't.
+		{name: "unknown apko key", config: `{"wolfinit": {}, "something-new": true}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseConfig([]byte(tc.config), false, false); err != nil {
+				t.Errorf("parseConfig() = %v, without strict", err)
+			}
+			_, err := parseConfig([]byte(tc.config), false, true)
+			if tc.wantErr && err == nil {
+				t.Error("parseConfig() = nil, wanted an error")
+			} else if !tc.wantErr && err != nil {
+				t.Errorf("parseConfig() = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckConfigNoEntrypoint(t *testing.T) {
+	for _, config := range []string{`{}`, `{"wolfinit": {"interpreter": "  "}}`} {
+		path := filepath.Join(t.TempDir(), "apko.json")
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := checkConfig([]string{"-config", path}); got != 1 {
+			t.Errorf("checkConfig(%s) = %d, want 1", config, got)
+		}
+	}
+}
+
+func TestCheckConfigComments(t *testing.T) {
+	const config = `{
+		// The entrypoint.
+		"entrypoint": {"command": "/bin/true"}
+	}`
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		file string
+		args []string
+		want int
+	}{
+		{name: "json", file: "apko.json", want: 1},
+		{name: "jsonc", file: "apko.jsonc", want: 0},
+		{name: "json with -comments", file: "apko.json", args: []string{"-comments"}, want: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := checkConfig(append(tc.args, "-config", path)); got != tc.want {
+				t.Errorf("checkConfig() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+// mainService returns the name of the service designated as the main process,
+// if any. Every service must be named, and at most one may be the main one.
+func mainService(services []Service) (string, error) {
+	name := ""
+	for i, svc := range services {
+		if svc.Name == "" {
+			return "", fmt.Errorf("service %d has no name", i)
+		}
+		if !svc.Main {
+			continue
+		}
+		if name != "" {
+			return "", fmt.Errorf("both %s and %s are marked main", name, svc.Name)
+		}
+		name = svc.Name
+	}
+	return name, nil
+}
+
+// startServices starts each service alongside the entrypoint, with the given
+// environment, working directory and credentials, restarting them whenever
+// they exit. If the main service exits, mainExited is called instead. The
+// returned function asks every service to exit, with SIGTERM, and waits
+// until they have.
+func startServices(services []Service, env []string, dir string, cred *syscall.Credential, killAfter time.Duration, mainExited func()) (func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, svc := range services {
+		args, err := shlex.Split(svc.Command)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return nil, fmt.Errorf("splitting service %s command: %w", svc.Name, err)
+		} else if len(args) == 0 {
+			cancel()
+			wg.Wait()
+			return nil, fmt.Errorf("empty service %s command", svc.Name)
+		}
+		wg.Add(1)
+		go func(svc Service) {
+			defer wg.Done()
+			for {
+				log.Printf("starting service %s: %v", svc.Name, args)
+				cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+				cmd.Dir = dir
+				cmd.Env = env
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+				cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+				// Ask the service to exit, and only kill it if it doesn't
+				// within killAfter.
+				cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+				cmd.WaitDelay = killAfter
+				err := runWaited(cmd)
+				if ctx.Err() != nil {
+					return
+				}
+				if svc.Main {
+					log.Printf("main service %s exited (%v), shutting down", svc.Name, exitDescription(err))
+					mainExited()
+					return
+				}
+				log.Printf("service %s exited (%v), restarting in %v", svc.Name, exitDescription(err), superviseRestartDelay)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(superviseRestartDelay):
+				}
+			}
+		}(svc)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}, nil
+}
@@ -0,0 +1,68 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// signalMask is the change to make to the signal mask the entrypoint starts
+// with.
+type signalMask struct {
+	block, unblock unix.Sigset_t
+}
+
+// parseSignalMask checks the names of the signals to block and unblock (e.g.
+// "SIGHUP", or just "HUP").
+func parseSignalMask(sm SignalMask) (*signalMask, error) {
+	m := &signalMask{}
+	if err := addSignals(&m.block, sm.Block); err != nil {
+		return nil, err
+	}
+	if err := addSignals(&m.unblock, sm.Unblock); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// addSignals adds the named signals to the set.
+func addSignals(set *unix.Sigset_t, names []string) error {
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		sig := unix.SignalNum(name)
+		switch sig {
+		case 0:
+			return fmt.Errorf("unknown signal %q", name)
+		case unix.SIGKILL, unix.SIGSTOP:
+			return fmt.Errorf("%s can't be blocked", name)
+		}
+		bits := uint(unsafe.Sizeof(set.Val[0])) * 8
+		n := uint(sig) - 1
+		set.Val[n/bits] |= 1 << (n % bits)
+	}
+	return nil
+}
+
+// apply changes the signal mask of the calling thread, which the entrypoint
+// inherits when it is forked from it. It must be run on a thread that is
+// then thrown away, with startOnThread.
+func (m *signalMask) apply() error {
+	if err := unix.PthreadSigmask(unix.SIG_BLOCK, &m.block, nil); err != nil {
+		return fmt.Errorf("blocking signals: %w", err)
+	}
+	if err := unix.PthreadSigmask(unix.SIG_UNBLOCK, &m.unblock, nil); err != nil {
+		return fmt.Errorf("unblocking signals: %w", err)
+	}
+	return nil
+}
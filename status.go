@@ -0,0 +1,222 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// The phases that init moves through, in order.
+const (
+	phaseMounting   = "mounting"
+	phaseNetworking = "networking"
+	phaseStarting   = "starting"
+	phaseRunning    = "running"
+	phaseStopping   = "stopping"
+	phaseExited     = "exited"
+)
+
+// Status is a snapshot of where init is in the VM lifecycle.
+type Status struct {
+	// The current init phase.
+	Phase string `json:"phase"`
+	// The PID of the entrypoint, while it is running.
+	PID int `json:"pid,omitempty"`
+	// Whether the entrypoint is running.
+	Ready bool `json:"ready"`
+	// Why init failed, if it did.
+	Error string `json:"error,omitempty"`
+	// The code for the category of init failure, if init failed. This is
+	// only reported, since init powers off rather than exiting with it.
+	ExitCode int `json:"exit-code,omitempty"`
+	// The exit code of the entrypoint, once it has exited.
+	EntrypointExitCode *int `json:"entrypoint-exit-code,omitempty"`
+}
+
+var (
+	statusMu sync.Mutex
+	status   = Status{Phase: phaseMounting}
+	// When set, the file the status is written to whenever it changes.
+	statusFile string
+	// Whether the entrypoint leads its own session, in which case signals
+	// are sent to its whole process group.
+	sessionLeader bool
+)
+
+// setPhase records that init has moved on to the given phase.
+func setPhase(phase string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.Phase = phase
+	if phase != phaseRunning {
+		status.Ready = false
+		status.PID = 0
+	}
+	writeStatusFile()
+}
+
+// setStopping records that the entrypoint has exited with the given code.
+func setStopping(code int) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.Phase = phaseStopping
+	status.Ready = false
+	status.PID = 0
+	status.EntrypointExitCode = &code
+	writeStatusFile()
+}
+
+// setRunning records that the entrypoint has started with the given PID, and
+// whether it is the leader of its own session.
+func setRunning(pid int, leader bool) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.Phase = phaseRunning
+	status.PID = pid
+	status.Ready = true
+	status.EntrypointExitCode = nil
+	sessionLeader = leader
+	writeStatusFile()
+}
+
+// setReaped records that the entrypoint's process has been waited for, so that
+// its PID may already belong to some other process, and must no longer be
+// signalled. With an exit fifo, its exit status is yet to come.
+func setReaped() {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.PID = 0
+	writeStatusFile()
+}
+
+// setFailed records that init has failed.
+func setFailed(err *InitError) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	status.Error = err.Error()
+	status.ExitCode = err.ExitCode()
+	writeStatusFile()
+}
+
+// setStatusFile starts writing the status to path whenever it changes,
+// starting with the current status.
+func setStatusFile(path string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	statusFile = path
+	writeStatusFile()
+}
+
+// writeStatusFile writes the status to the status file, if there is one. The
+// file is replaced atomically, so that readers never see a partial status.
+// statusMu must be held.
+func writeStatusFile() {
+	if statusFile == "" {
+		return
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("failed to marshal status: %v", err)
+		return
+	}
+	tmp := statusFile + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		log.Printf("failed to write status file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, statusFile); err != nil {
+		log.Printf("failed to write status file: %v", err)
+	}
+}
+
+// signalEntrypoint sends sig to the entrypoint, or to its process group if it
+// leads its own session.
+func signalEntrypoint(sig syscall.Signal) error {
+	statusMu.Lock()
+	pid, leader := status.PID, sessionLeader
+	statusMu.Unlock()
+	if pid == 0 {
+		return fmt.Errorf("entrypoint is not running")
+	}
+	if leader {
+		// A session leader is also its process group leader, so this reaches
+		// any children it has started too.
+		pid = -pid
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// defaultBootMarker is the text of the line announcing that the entrypoint
+// has started, when no other text is configured.
+const defaultBootMarker = "WOLFINIT: boot-complete"
+
+// announceBoot prints the boot-complete marker for the entrypoint's pid to
+// the console, and writes it to file if one is configured.
+func announceBoot(marker, file string, pid int) {
+	if marker == "" {
+		marker = defaultBootMarker
+	}
+	line := fmt.Sprintf("%s pid=%d\n", marker, pid)
+	// This is deliberately not logged, so that the line isn't prefixed.
+	fmt.Fprint(os.Stdout, line)
+	if file != "" {
+		if err := os.WriteFile(file, []byte(line), 0644); err != nil {
+			log.Printf("failed to write boot marker to %s: %v", file, err)
+		}
+	}
+}
+
+// currentStatus returns a snapshot of the current status.
+func currentStatus() Status {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	return status
+}
+
+// serveStatus listens on the unix socket at path, and writes the current
+// status as JSON to each connection before closing it. The returned function
+// stops the server and removes the socket.
+func serveStatus(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Clean up any stale socket from a previous run.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("failed to accept status connection: %v", err)
+				}
+				return
+			}
+			if err := json.NewEncoder(conn).Encode(currentStatus()); err != nil {
+				log.Printf("failed to write status: %v", err)
+			}
+			conn.Close()
+		}
+	}()
+	return func() {
+		// Closing a unix listener also removes the socket file.
+		l.Close()
+	}, nil
+}
@@ -0,0 +1,26 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestSignalReapedEntrypoint(t *testing.T) {
+	// A PID that is surely not ours to signal.
+	setRunning(1<<22+1, false)
+	defer setPhase(phaseExited)
+	setReaped()
+
+	if s := currentStatus(); s.PID != 0 {
+		t.Errorf("PID = %d after reaping, want 0", s.PID)
+	}
+	if err := signalEntrypoint(syscall.SIGTERM); err == nil {
+		t.Error("signalEntrypoint() succeeded after the entrypoint was reaped")
+	}
+}
@@ -0,0 +1,100 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// stdinInherit is the Stdin setting that passes init's own stdin through to
+// the entrypoint.
+const stdinInherit = "inherit"
+
+// stdinVsockPrefix is the prefix of the Stdin setting that reads the
+// entrypoint's stdin from a connection to a vsock port, e.g. vsock:1025
+const stdinVsockPrefix = "vsock:"
+
+// isTerminal returns whether the file is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// openStdin returns the file the entrypoint should read its stdin from,
+// based on the configured setting.
+func openStdin(setting string) (*os.File, error) {
+	switch setting {
+	case stdinInherit:
+		return os.Stdin, nil
+	case "":
+		// Only hand over our stdin when there is a console someone could be
+		// typing into, since otherwise reads may block forever.
+		if isTerminal(os.Stdin) {
+			return os.Stdin, nil
+		}
+		return os.Open(os.DevNull)
+	default:
+		if p, ok := strings.CutPrefix(setting, stdinVsockPrefix); ok {
+			port, err := strconv.ParseUint(p, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("malformed vsock port %q: %w", p, err)
+			}
+			return vsockStdin(uint32(port))
+		}
+		return os.Open(setting)
+	}
+}
+
+// vsockStdin returns a pipe that is fed from the first connection the host
+// makes to the given vsock port. Until then reads block, and once the host
+// closes the connection they see EOF.
+func vsockStdin(port uint32) (*os.File, error) {
+	fd, err := listenVsock(port)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	go func() {
+		defer w.Close()
+		// Only one session is served, since the entrypoint only has the one
+		// stdin.
+		defer unix.Close(fd)
+		var (
+			nfd int
+			err error
+		)
+		for {
+			nfd, _, err = unix.Accept4(fd, unix.SOCK_CLOEXEC)
+			if err != unix.EINTR {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("failed to accept stdin connection: %v", err)
+			return
+		}
+		conn := os.NewFile(uintptr(nfd), "vsock")
+		defer conn.Close()
+		log.Printf("feeding the entrypoint's stdin from vsock port %d", port)
+		if _, err := io.Copy(w, conn); err != nil {
+			log.Printf("failed to copy stdin from vsock: %v", err)
+		}
+		log.Printf("stdin connection on vsock port %d closed", port)
+	}()
+	return r, nil
+}
@@ -0,0 +1,51 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/google/shlex"
+)
+
+// defaultStraceOutput is where the trace is written, unless configured
+// otherwise.
+const defaultStraceOutput = "/tmp/wolfinit.strace"
+
+// straceArgs wraps args to run under strace, following forks and writing the
+// trace to the configured output, which is created for the run-as user (as
+// strace runs as it too).
+func straceArgs(cfg Strace, args []string, uid, gid int) ([]string, error) {
+	path, err := exec.LookPath("strace")
+	if err != nil {
+		return nil, err
+	}
+	out := cfg.Output
+	if out == "" {
+		out = defaultStraceOutput
+	}
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chown(out, uid, gid); err != nil {
+		return nil, err
+	}
+	options, err := shlex.Split(cfg.Options)
+	if err != nil {
+		return nil, fmt.Errorf("splitting strace options: %w", err)
+	}
+	wrapped := []string{path, "-f", "-o", out}
+	wrapped = append(wrapped, options...)
+	wrapped = append(wrapped, "--")
+	return append(wrapped, args...), nil
+}
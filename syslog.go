@@ -0,0 +1,158 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// syslogSocket is where the image's syslog daemon listens.
+	syslogSocket = "/dev/log"
+	// defaultSyslogTag is the tag of the entrypoint's messages, unless
+	// configured otherwise.
+	defaultSyslogTag = "entrypoint"
+	// maxSyslogLine is the most of a line we send as one message, with
+	// longer lines split across several.
+	maxSyslogLine = 4096
+	// syslogRedialInterval is how long to send output to the console after
+	// failing to connect to syslog, before trying again.
+	syslogRedialInterval = time.Second
+)
+
+// syslogFacilities are the facilities by name.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern": syslog.LOG_KERN, "user": syslog.LOG_USER, "mail": syslog.LOG_MAIL,
+	"daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "syslog": syslog.LOG_SYSLOG,
+	"lpr": syslog.LOG_LPR, "news": syslog.LOG_NEWS, "uucp": syslog.LOG_UUCP,
+	"cron": syslog.LOG_CRON, "authpriv": syslog.LOG_AUTHPRIV, "ftp": syslog.LOG_FTP,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3, "local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+// syslogSeverities are the severities by name.
+var syslogSeverities = map[string]syslog.Priority{
+	"emerg": syslog.LOG_EMERG, "alert": syslog.LOG_ALERT, "crit": syslog.LOG_CRIT,
+	"err": syslog.LOG_ERR, "warning": syslog.LOG_WARNING, "notice": syslog.LOG_NOTICE,
+	"info": syslog.LOG_INFO, "debug": syslog.LOG_DEBUG,
+}
+
+// syslogPriority returns the priority for the named facility (default user)
+// and severity (default info).
+func syslogPriority(facility, severity string) (syslog.Priority, error) {
+	if facility == "" {
+		facility = "user"
+	}
+	if severity == "" {
+		severity = "info"
+	}
+	f, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	s, ok := syslogSeverities[strings.ToLower(severity)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog severity %q", severity)
+	}
+	return f | s, nil
+}
+
+// syslogWriter sends each line of the entrypoint's output to syslog as a
+// message of its own. The syslog daemon may be started after us (e.g. as a
+// service, or by the entrypoint itself), so we only connect once there is
+// output, and until we can, output goes to the console instead.
+type syslogWriter struct {
+	priority syslog.Priority
+	tag      string
+	socket   string
+	console  io.Writer
+
+	mu sync.Mutex
+	w  *syslog.Writer
+	// When not connected, the earliest we try connecting again.
+	redialAt time.Time
+	// The start of a line that hasn't been ended yet.
+	partial []byte
+}
+
+// newSyslogWriter returns a writer that sends lines to syslog, falling back
+// to console.
+func newSyslogWriter(cfg Syslog, console io.Writer) (*syslogWriter, error) {
+	priority, err := syslogPriority(cfg.Facility, cfg.Severity)
+	if err != nil {
+		return nil, err
+	}
+	tag := cfg.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	return &syslogWriter{priority: priority, tag: tag, socket: syslogSocket, console: console}, nil
+}
+
+func (sw *syslogWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for rest := p; len(rest) != 0; {
+		line, after, found := bytes.Cut(rest, []byte{'\n'})
+		for len(line) != 0 {
+			n := min(len(line), maxSyslogLine-len(sw.partial))
+			sw.partial = append(sw.partial, line[:n]...)
+			line = line[n:]
+			if len(sw.partial) == maxSyslogLine {
+				sw.send()
+			}
+		}
+		if found && len(sw.partial) != 0 {
+			sw.send()
+		}
+		rest = after
+	}
+	return len(p), nil
+}
+
+// flush sends any line that hasn't been ended, e.g. once the entrypoint has
+// exited.
+func (sw *syslogWriter) flush() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if len(sw.partial) != 0 {
+		sw.send()
+	}
+}
+
+// send sends the pending line, connecting first if we haven't yet. While
+// there is nothing to connect to, we only try again every
+// syslogRedialInterval, rather than for every line.
+func (sw *syslogWriter) send() {
+	defer func() { sw.partial = sw.partial[:0] }()
+	if sw.w == nil {
+		if time.Now().Before(sw.redialAt) {
+			fmt.Fprintf(sw.console, "%s\n", sw.partial)
+			return
+		}
+		w, err := syslog.Dial("unixgram", sw.socket, sw.priority, sw.tag)
+		if err != nil {
+			sw.redialAt = time.Now().Add(syslogRedialInterval)
+			fmt.Fprintf(sw.console, "%s\n", sw.partial)
+			return
+		}
+		log.Printf("sending the entrypoint's output to syslog")
+		sw.w = w
+	}
+	// The syslog package reconnects if the daemon is restarted.
+	if _, err := sw.w.Write(sw.partial); err != nil {
+		fmt.Fprintf(sw.console, "%s\n", sw.partial)
+	}
+}
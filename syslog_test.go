@@ -0,0 +1,113 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenSyslog listens where w sends to syslog, returning the messages it
+// receives.
+func listenSyslog(t *testing.T, w *syslogWriter) <-chan string {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: w.socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	msgs := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			// Only the message itself, after the priority, time and tag.
+			_, msg, _ := strings.Cut(string(buf[:n]), "]: ")
+			msgs <- strings.TrimSuffix(msg, "\n")
+		}
+	}()
+	return msgs
+}
+
+func newTestSyslogWriter(t *testing.T, console *bytes.Buffer) *syslogWriter {
+	t.Helper()
+	w, err := newSyslogWriter(Syslog{}, console)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.socket = filepath.Join(t.TempDir(), "log")
+	return w
+}
+
+func receive(t *testing.T, msgs <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message sent to syslog")
+		return ""
+	}
+}
+
+// A long line is split into messages that each fit, however it is written.
+func TestSyslogLongLine(t *testing.T) {
+	var console bytes.Buffer
+	w := newTestSyslogWriter(t, &console)
+	msgs := listenSyslog(t, w)
+
+	line := strings.Repeat("a", maxSyslogLine) + strings.Repeat("b", maxSyslogLine) + "cc"
+	for _, write := range [][]byte{[]byte(line), []byte("\nnext\n")} {
+		if _, err := w.Write(write); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{strings.Repeat("a", maxSyslogLine), strings.Repeat("b", maxSyslogLine), "cc", "next"} {
+		if got := receive(t, msgs); got != want {
+			t.Errorf("message of %d bytes, want %d (%.10q...)", len(got), len(want), want)
+		}
+	}
+	if console.Len() != 0 {
+		t.Errorf("console got %q", console.String())
+	}
+}
+
+// Without a syslog daemon, output goes to the console, and connecting is only
+// retried once in a while.
+func TestSyslogRedial(t *testing.T) {
+	var console bytes.Buffer
+	w := newTestSyslogWriter(t, &console)
+	if _, err := w.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := console.String(), "one\ntwo\n"; got != want {
+		t.Errorf("console = %q, want %q", got, want)
+	}
+
+	// The daemon starting isn't noticed until it is time to try again.
+	msgs := listenSyslog(t, w)
+	if _, err := w.Write([]byte("three\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(console.String(), "three\n") {
+		t.Errorf("console = %q, want the line written before retrying", console.String())
+	}
+	w.redialAt = time.Now()
+	if _, err := w.Write([]byte("four\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, msgs); got != "four" {
+		t.Errorf("message = %q, want four", got)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+)
+
+// userNamespace is how the entrypoint is run in a new user namespace.
+type userNamespace struct {
+	uids, gids []syscall.SysProcIDMap
+	// The credentials to run as, from inside the namespace.
+	cred *syscall.Credential
+}
+
+// newUserNamespace converts the mappings, and translates the run-as
+// credentials (which are IDs outside the namespace) to the IDs they are
+// mapped to inside it. The run-as user and group must be mapped, while any
+// supplementary groups that aren't are dropped.
+func newUserNamespace(ns UserNamespace, cred *syscall.Credential) (*userNamespace, error) {
+	uns := &userNamespace{
+		uids: idMaps(ns.UIDMappings),
+		gids: idMaps(ns.GIDMappings),
+	}
+	if len(uns.uids) == 0 || len(uns.gids) == 0 {
+		return nil, fmt.Errorf("uid-mappings and gid-mappings are both required")
+	}
+	uid, ok := mapID(uns.uids, cred.Uid)
+	if !ok {
+		return nil, fmt.Errorf("run-as uid %d is not mapped", cred.Uid)
+	}
+	gid, ok := mapID(uns.gids, cred.Gid)
+	if !ok {
+		return nil, fmt.Errorf("run-as gid %d is not mapped", cred.Gid)
+	}
+	uns.cred = &syscall.Credential{Uid: uid, Gid: gid}
+	for _, g := range cred.Groups {
+		if mapped, ok := mapID(uns.gids, g); ok {
+			uns.cred.Groups = append(uns.cred.Groups, mapped)
+		} else {
+			log.Printf("dropping supplementary group %d, which is not mapped into the user namespace", g)
+		}
+	}
+	log.Printf("running the entrypoint in a user namespace as %d:%d", uid, gid)
+	return uns, nil
+}
+
+// idMaps converts the configured mappings for syscall.
+func idMaps(mappings []IDMapping) []syscall.SysProcIDMap {
+	var maps []syscall.SysProcIDMap
+	for _, m := range mappings {
+		maps = append(maps, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return maps
+}
+
+// mapID returns the ID inside the namespace that the host ID is mapped to.
+func mapID(maps []syscall.SysProcIDMap, id uint32) (uint32, bool) {
+	for _, m := range maps {
+		if m.Size > 0 && int(id) >= m.HostID && int(id) < m.HostID+m.Size {
+			return uint32(m.ContainerID + int(id) - m.HostID), true
+		}
+	}
+	return 0, false
+}
+
+// apply runs the process with attr in the namespace. The mappings are
+// written by the parent once the child is cloned, and the child waits for
+// them before switching to the credentials, so the credential drop always
+// happens against the new mappings.
+func (uns *userNamespace) apply(attr *syscall.SysProcAttr) {
+	attr.Cloneflags |= syscall.CLONE_NEWUSER
+	attr.UidMappings = uns.uids
+	attr.GidMappings = uns.gids
+	// Init is privileged outside the namespace, so setgroups can be left
+	// enabled for the supplementary groups.
+	attr.GidMappingsEnableSetgroups = true
+	attr.Credential = uns.cred
+}
@@ -0,0 +1,119 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/google/shlex"
+)
+
+const (
+	defaultWaitForTimeout = 30 * time.Second
+	waitForInterval       = 500 * time.Millisecond
+)
+
+// The actions to take when a WaitFor condition times out.
+const (
+	// Start the entrypoint anyway (the default).
+	waitForProceed = "proceed"
+	// Fail, which powers off the VM.
+	waitForFatal = "fatal"
+)
+
+// describe returns a human-readable description of the condition.
+func (w WaitFor) describe() string {
+	switch {
+	case w.File != "":
+		return fmt.Sprintf("file %s", w.File)
+	case w.TCP != "":
+		return fmt.Sprintf("tcp %s", w.TCP)
+	case w.Command != "":
+		return fmt.Sprintf("command %q", w.Command)
+	case w.DNS != "":
+		return fmt.Sprintf("dns %s", w.DNS)
+	default:
+		return "nothing"
+	}
+}
+
+// check returns nil once the condition holds.
+func (w WaitFor) check(ctx context.Context, env []string) error {
+	switch {
+	case w.File != "":
+		_, err := os.Stat(w.File)
+		return err
+	case w.TCP != "":
+		conn, err := (&net.Dialer{Timeout: waitForInterval}).DialContext(ctx, "tcp", w.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case w.Command != "":
+		args, err := shlex.Split(w.Command)
+		if err != nil {
+			return err
+		} else if len(args) == 0 {
+			return fmt.Errorf("empty command")
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = env
+		return runWaited(cmd)
+	case w.DNS != "":
+		// This catches networks that got an address, but not a working
+		// nameserver.
+		_, err := net.DefaultResolver.LookupHost(ctx, w.DNS)
+		return err
+	default:
+		return fmt.Errorf("one of file, tcp, command or dns must be set")
+	}
+}
+
+// waitFor blocks until the condition holds, or its timeout elapses.
+func waitFor(ctx context.Context, w WaitFor, env []string) error {
+	timeout := defaultWaitForTimeout
+	if w.Timeout != nil {
+		timeout = time.Duration(*w.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("waiting up to %v for %s", timeout, w.describe())
+	start := time.Now()
+	for {
+		err := w.check(ctx, env)
+		if err == nil {
+			log.Printf("%s is ready after %v", w.describe(), time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s: %w", w.describe(), err)
+		case <-time.After(waitForInterval):
+		}
+	}
+}
+
+// waitForAll waits for each of the conditions in turn, applying the action
+// to any that time out.
+func waitForAll(ctx context.Context, conditions []WaitFor, action string, env []string) error {
+	for _, w := range conditions {
+		if err := waitFor(ctx, w, env); err != nil {
+			if action == waitForFatal {
+				return err
+			}
+			log.Printf("%v, proceeding anyway", err)
+		}
+	}
+	return nil
+}
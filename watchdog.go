@@ -0,0 +1,174 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultBootTimeout is how long init may take to start the entrypoint
+// before we give up and power off. This is deliberately generous, since DHCP
+// retries alone can take the better part of a minute.
+const defaultBootTimeout = 5 * time.Minute
+
+// startBootWatchdog powers off the VM if the returned function (which stops
+// the watchdog) isn't called within timeout, so that a hung init step doesn't
+// leave a VM that neither boots nor exits. A zero timeout disables it.
+func startBootWatchdog(timeout time.Duration) func() {
+	if timeout <= 0 {
+		return func() {}
+	}
+	t := time.AfterFunc(timeout, func() {
+		log.Printf("entrypoint did not start within %v, stuck in phase %q", timeout, currentStatus().Phase)
+		// Dump what every goroutine is doing, to show which step hung.
+		buf := make([]byte, 1<<20)
+		log.Printf("goroutines:\n%s", buf[:runtime.Stack(buf, true)])
+		shutdown()
+	})
+	return func() { t.Stop() }
+}
+
+const (
+	defaultHardwareWatchdog = "/dev/watchdog"
+	// defaultWatchdogInterval is how often the hardware watchdog is petted,
+	// when its timeout can't be read to pick half of it.
+	defaultWatchdogInterval = 10 * time.Second
+)
+
+var (
+	// heartbeats counts the supervision loop's check-ins, which the hardware
+	// watchdog's petter looks for while supervising is set.
+	heartbeats  atomic.Uint64
+	supervising atomic.Bool
+	// heartbeatInterval is how often the supervision loop checks in, or zero
+	// when no hardware watchdog is being petted.
+	heartbeatInterval time.Duration
+)
+
+// heartbeat records that init is still making progress. Taking the locks that
+// reaping and the status need along the way means a deadlock on either stops
+// the heartbeat too, rather than only a hang of the calling goroutine.
+func heartbeat() {
+	reapMu.Lock()
+	reapMu.Unlock()
+	currentStatus()
+	heartbeats.Add(1)
+}
+
+// superviseWait calls wait (waiting for the entrypoint to exit), checking in
+// with the hardware watchdog every heartbeatInterval until it returns. Until
+// then, the watchdog is only petted while those heartbeats keep coming.
+func superviseWait(wait func() error) error {
+	if heartbeatInterval <= 0 {
+		return wait()
+	}
+	heartbeat()
+	supervising.Store(true)
+	defer supervising.Store(false)
+
+	errc := make(chan error, 1)
+	go func() { errc <- wait() }()
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-t.C:
+			heartbeat()
+		}
+	}
+}
+
+// startHardwareWatchdog opens the hypervisor's watchdog device and pets it
+// every interval (by default half its timeout), so that the VM is reset if
+// init stops doing so. While superviseWait is waiting for the entrypoint, it
+// is only petted if init checked in since the last time, so that a hung
+// supervision loop resets the VM as well. The returned function stops petting
+// it and disarms it, for shutting down.
+func startHardwareWatchdog(cfg HardwareWatchdog) func() {
+	path := cfg.Device
+	if path == "" {
+		path = defaultHardwareWatchdog
+	}
+	// Opening the device arms it.
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		log.Printf("not petting the hardware watchdog: %v", err)
+		return func() {}
+	}
+	interval := time.Duration(cfg.Interval)
+	if timeout, err := unix.IoctlGetInt(int(f.Fd()), unix.WDIOC_GETTIMEOUT); err != nil {
+		log.Printf("failed to read the timeout of %s: %v", path, err)
+	} else {
+		if interval == 0 {
+			interval = time.Duration(timeout) * time.Second / 2
+		} else if interval >= time.Duration(timeout)*time.Second {
+			log.Printf("petting %s every %v, which is not within its %ds timeout", path, interval, timeout)
+		}
+	}
+	if interval <= 0 {
+		interval = defaultWatchdogInterval
+	}
+	log.Printf("petting %s every %v", path, interval)
+	// Check in twice per pet, so that one is always due by the next.
+	heartbeatInterval = interval / 2
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		var seen uint64
+		missed := false
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				beats := heartbeats.Load()
+				if supervising.Load() && beats == seen {
+					if !missed {
+						log.Printf("init stopped checking in while supervising the entrypoint, no longer petting %s", path)
+						missed = true
+					}
+					continue
+				}
+				if missed {
+					log.Printf("init checked in again, petting %s", path)
+					missed = false
+				}
+				seen = beats
+				// Any write counts as a keepalive.
+				if _, err := f.Write([]byte{0}); err != nil {
+					log.Printf("failed to pet %s: %v", path, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		heartbeatInterval = 0
+		close(done)
+		<-stopped
+		// Writing the magic 'V' before closing disarms it, where the driver
+		// allows that.
+		if _, err := f.Write([]byte("V")); err != nil {
+			log.Printf("failed to disarm %s: %v", path, err)
+		}
+		if err := f.Close(); err != nil {
+			log.Printf("failed to close %s: %v", path, err)
+		}
+		log.Printf("stopped petting %s", path)
+	}
+}
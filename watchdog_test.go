@@ -0,0 +1,74 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHardwareWatchdogHeartbeat(t *testing.T) {
+	// A plain file stands in for the device, so each pet grows it by a byte.
+	dev := filepath.Join(t.TempDir(), "watchdog")
+	if err := os.WriteFile(dev, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pets := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	const interval = 20 * time.Millisecond
+	// petted reports whether the watchdog is petted within a few intervals.
+	petted := func() bool {
+		before := pets()
+		time.Sleep(5 * interval)
+		return pets() > before
+	}
+
+	stop := startHardwareWatchdog(HardwareWatchdog{Device: dev, Interval: Duration(interval)})
+	if !petted() {
+		t.Fatal("not petted before supervising the entrypoint")
+	}
+
+	exit := make(chan struct{})
+	waited := make(chan error, 1)
+	go func() {
+		waited <- superviseWait(func() error {
+			<-exit
+			return nil
+		})
+	}()
+	if !petted() {
+		t.Error("not petted while supervising the entrypoint")
+	}
+
+	// Wedging the reaper's lock stops the heartbeat, and so the petting.
+	reapMu.Lock()
+	time.Sleep(2 * interval)
+	if petted() {
+		t.Error("petted while init is stuck")
+	}
+	reapMu.Unlock()
+	if !petted() {
+		t.Error("not petted once init is unstuck")
+	}
+
+	close(exit)
+	if err := <-waited; err != nil {
+		t.Errorf("superviseWait() = %v", err)
+	}
+	stop()
+	if heartbeatInterval != 0 {
+		t.Errorf("heartbeatInterval = %v after stopping, wanted 0", heartbeatInterval)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// The policies for a working directory that doesn't exist once everything
+// has been mounted.
+const (
+	// Fail, which powers off the VM (the default).
+	missingWorkDirFail = "fail"
+	// Create the directory.
+	missingWorkDirCreate = "create"
+	// Run in / instead.
+	missingWorkDirRoot = "root"
+)
+
+// resolveWorkDir returns the working directory to run the entrypoint in, and
+// whether it was created. This must only be called once the configured mounts
+// are in place, since the directory may be on one of them.
+func resolveWorkDir(dir, policy string) (string, bool, error) {
+	if dir == "" {
+		return "", false, nil
+	}
+	fi, err := os.Stat(dir)
+	if err == nil {
+		if !fi.IsDir() {
+			return "", false, fmt.Errorf("working directory %s is not a directory", dir)
+		}
+		return dir, false, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", false, err
+	}
+	switch policy {
+	case missingWorkDirCreate:
+		log.Printf("creating missing working directory %s", dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", false, err
+		}
+		return dir, true, nil
+	case missingWorkDirRoot:
+		log.Printf("working directory %s does not exist, using /", dir)
+		return "/", false, nil
+	case "", missingWorkDirFail:
+		return "", false, fmt.Errorf("working directory %s does not exist", dir)
+	default:
+		return "", false, fmt.Errorf("unknown missing-work-dir policy %q", policy)
+	}
+}
+
+// chownWorkDir gives a working directory we created to the user the
+// entrypoint runs as, so that it can write to it like it could to one from
+// the image. Any parents that were created with it stay owned by root.
+func chownWorkDir(dir string, uid, gid int) error {
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return err
+	}
+	log.Printf("changed the owner of working directory %s to %d:%d", dir, uid, gid)
+	return nil
+}
@@ -0,0 +1,147 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+// Copyright 2024 Chainguard, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestResolveWorkDir(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmp, "missing", "app")
+
+	for _, tc := range []struct {
+		name    string
+		dir     string
+		policy  string
+		want    string
+		created bool
+		wantErr bool
+	}{
+		{name: "unset", dir: "", want: ""},
+		{name: "exists", dir: tmp, want: tmp},
+		{name: "exists with create", dir: tmp, policy: missingWorkDirCreate, want: tmp},
+		{name: "not a directory", dir: file, policy: missingWorkDirCreate, wantErr: true},
+		{name: "missing", dir: missing, wantErr: true},
+		{name: "missing with fail", dir: missing, policy: missingWorkDirFail, wantErr: true},
+		{name: "missing with root", dir: missing, policy: missingWorkDirRoot, want: "/"},
+		{name: "unknown policy", dir: missing, policy: "guess", wantErr: true},
+		// This one goes last, since it creates the directory.
+		{name: "missing with create", dir: missing, policy: missingWorkDirCreate, want: missing, created: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, created, err := resolveWorkDir(tc.dir, tc.policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("resolveWorkDir() = %q, want an error", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("resolveWorkDir() = %v", err)
+			}
+			if got != tc.want || created != tc.created {
+				t.Errorf("resolveWorkDir() = %q, %v, want %q, %v", got, created, tc.want, tc.created)
+			}
+		})
+	}
+	if fi, err := os.Stat(missing); err != nil || !fi.IsDir() {
+		t.Errorf("created working directory: %v, %v", fi, err)
+	}
+}
+
+// The working directory may only exist once a configured mount is in place,
+// which is why it is resolved after mounting.
+func TestResolveWorkDirOnMount(t *testing.T) {
+	src, target := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(src, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(target, "app")
+	if _, _, err := resolveWorkDir(dir, missingWorkDirFail); err == nil {
+		t.Fatal("resolveWorkDir() succeeded before mounting")
+	}
+
+	// mount --bind <src> <target>
+	if err := unix.Mount(src, target, "", unix.MS_BIND, ""); err != nil {
+		t.Skipf("can't mount: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := unix.Unmount(target, 0); err != nil {
+			t.Errorf("unmounting %s: %v", target, err)
+		}
+	})
+	got, created, err := resolveWorkDir(dir, missingWorkDirFail)
+	if err != nil {
+		t.Fatalf("resolveWorkDir() after mounting = %v", err)
+	} else if got != dir || created {
+		t.Errorf("resolveWorkDir() = %q, %v, want %q, false", got, created, dir)
+	}
+}
+
+// A working directory init created is given to the entrypoint's user, while
+// one from the image keeps its owner.
+func TestChownWorkDir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+	const uid, gid = 1000, 1001
+	tmp := t.TempDir()
+	for _, tc := range []struct {
+		name    string
+		dir     string
+		created bool
+	}{
+		{name: "existing", dir: tmp, created: false},
+		{name: "created", dir: filepath.Join(tmp, "missing", "app"), created: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var before unix.Stat_t
+			if err := unix.Stat(tc.dir, &before); err != nil && !tc.created {
+				t.Fatal(err)
+			}
+			// As main does, once the entrypoint's user is known.
+			dir, created, err := resolveWorkDir(tc.dir, missingWorkDirCreate)
+			if err != nil {
+				t.Fatalf("resolveWorkDir() = %v", err)
+			} else if created != tc.created {
+				t.Fatalf("resolveWorkDir() created = %v, want %v", created, tc.created)
+			}
+			if created {
+				if err := chownWorkDir(dir, uid, gid); err != nil {
+					t.Fatalf("chownWorkDir() = %v", err)
+				}
+			}
+
+			var st unix.Stat_t
+			if err := unix.Stat(dir, &st); err != nil {
+				t.Fatal(err)
+			}
+			wantUID, wantGID := before.Uid, before.Gid
+			if tc.created {
+				wantUID, wantGID = uid, gid
+				// Its parents stay owned by root.
+				var parent unix.Stat_t
+				if err := unix.Stat(filepath.Dir(dir), &parent); err != nil {
+					t.Fatal(err)
+				} else if parent.Uid != 0 || parent.Gid != 0 {
+					t.Errorf("parent owned by %d:%d, want 0:0", parent.Uid, parent.Gid)
+				}
+			}
+			if st.Uid != wantUID || st.Gid != wantGID {
+				t.Errorf("%s owned by %d:%d, want %d:%d", dir, st.Uid, st.Gid, wantUID, wantGID)
+			}
+		})
+	}
+}